@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/jellydator/ttlcache/v3"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/time/rate"
 	"idfm/pkg/data"
@@ -9,14 +10,30 @@ import (
 	"idfm/pkg/handlers"
 	"log"
 	"net/http"
+	"time"
 )
 
-// Define a rate limiter with 5 requests per second and a burst of 10.
-var limiter = rate.NewLimiter(5, 10)
+// maxLimiters caps the number of rate limiters kept, the least recently used ones being evicted first
+const maxLimiters = 10000
+
+// Requests without a client API key share the limiter of the server keys, with 5 requests per second and a burst of 10.
+var serverLimiter = rate.NewLimiter(5, 10)
+
+// Requests with a client API key are limited per client IP, so that changing the key does not get a fresh limiter,
+// as well as per key. Keys are held by fingerprint, and only once the request is within the limit of its IP.
+var limiters = ttlcache.New[string, *rate.Limiter](
+	ttlcache.WithTTL[string, *rate.Limiter](10*time.Minute),
+	ttlcache.WithCapacity[string, *rate.Limiter](maxLimiters),
+)
 
 // Middleware to check the rate limit.
 func rateLimiter(c *gin.Context) {
-	if !limiter.Allow() {
+	var clientKey string
+	if env.IDFM_ALLOW_CLIENT_KEYS {
+		clientKey = c.GetHeader(handlers.ClientKeyHeader)
+	}
+
+	if !allow(clientKey, c.ClientIP()) {
 		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
 		c.Abort()
 		return
@@ -24,31 +41,77 @@ func rateLimiter(c *gin.Context) {
 	c.Next()
 }
 
+// allow tells whether a request is within the rate limits of its client key and IP
+func allow(clientKey string, clientIP string) bool {
+	if clientKey == "" {
+		return serverLimiter.Allow()
+	}
+
+	ipLimiter, _ := limiters.GetOrSet("ip:"+clientIP, rate.NewLimiter(5, 10))
+	if !ipLimiter.Value().Allow() {
+		return false
+	}
+	keyLimiter, _ := limiters.GetOrSet("key:"+handlers.KeyFingerprint(clientKey), rate.NewLimiter(5, 10))
+	return keyLimiter.Value().Allow()
+}
+
 func main() {
-	if env.IDFM_API_KEY == "" {
-		log.Fatal("IDFM_API_KEY not defined. Please create an API key and try again.")
+	if len(env.IDFM_API_KEYS) == 0 && !env.IDFM_ALLOW_CLIENT_KEYS {
+		log.Fatal("IDFM_API_KEY or IDFM_API_KEYS not defined. Please create an API key, or set IDFM_ALLOW_CLIENT_KEYS, and try again.")
+	}
+
+	if env.IDFM_API_KEY_STRATEGY != "round-robin" && env.IDFM_API_KEY_STRATEGY != "quota" {
+		log.Fatalf("IDFM_API_KEY_STRATEGY \"%s\" is invalid. Valid strategies: round-robin, quota", env.IDFM_API_KEY_STRATEGY)
+	}
+
+	if err := env.CheckUpstreams(); err != nil {
+		log.Fatalf("Upstream check failed: %s", err)
 	}
 
 	r := gin.Default()
 
+	go limiters.Start()
 	r.Use(rateLimiter)
 
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status": "healthy",
-		})
-	})
+	r.GET("/health", handlers.HealthHandler())
+
+	server := handlers.NewDefaultServer()
 
 	// API group
-	idfm := r.Group("/api/idfm")
+	idfm := r.Group("/api/idfm", handlers.ClientKeyMiddleware())
 	{
-		idfm.GET("/lines/:type/:id", handlers.IDFMLineHandler())
-		idfm.GET("/timings/:type/:id/:stop", handlers.IDFMTimeHandler())
+		idfm.GET("/lines", server.IDFMLinesHandler())
+		idfm.GET("/lines/:type/:id", server.IDFMLineHandler())
+		idfm.GET("/lines/:type/:id/directions", server.IDFMLineDirectionsHandler())
+		idfm.GET("/operators", server.IDFMOperatorsHandler())
+		idfm.GET("/stops", server.IDFMStopSearchHandler())
+		idfm.GET("/nearby", server.IDFMNearbyHandler())
+		idfm.GET("/boards/:stop", server.IDFMBoardHandler())
+		idfm.GET("/timings/:type/:id/:stop", server.IDFMTimeHandler())
+		idfm.GET("/timings/stoppoint/:id", server.IDFMStopPointTimeHandler())
+		idfm.GET("/timings/stoparea/:id", server.IDFMStopAreaTimeHandler())
+	}
+
+	// v2 API group, with structured departures; the API group above keeps its responses unchanged
+	v2 := r.Group("/api/v2", handlers.ClientKeyMiddleware())
+	{
+		v2.GET("/boards/:stop", server.IDFMBoardHandlerV2())
+		v2.GET("/timings/:type/:id/:stop", server.IDFMTimeHandlerV2())
+		v2.GET("/timings/stoppoint/:id", server.IDFMStopPointTimeHandlerV2())
+		v2.GET("/timings/stoparea/:id", server.IDFMStopAreaTimeHandlerV2())
 	}
 
 	data.InitCache()
 
+	if err := data.InitReferential(); err != nil {
+		log.Fatalf("Offline referential could not be loaded: %s", err)
+	}
+
+	if err := data.InitDirections(); err != nil {
+		log.Fatalf("Direction rules could not be loaded: %s", err)
+	}
+
 	r.Run()
 }
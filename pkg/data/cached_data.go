@@ -4,12 +4,16 @@ import (
 	"github.com/jellydator/ttlcache/v3"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"idfm/pkg/env"
+	"idfm/pkg/internal/referential"
+	"idfm/pkg/internal/siri"
 	"idfm/pkg/internal/utils"
 	"time"
 )
 
 type LineCacheKey struct {
 	LineType string
+	Submode  string
 	LineId   string
 	Operator string
 }
@@ -26,12 +30,30 @@ var (
 		ttlcache.WithTTL[LineCacheKey, string](12*time.Hour),
 		ttlcache.WithCapacity[LineCacheKey, string](100),
 	)
+	LineByIdCache = ttlcache.New[string, referential.Line](
+		ttlcache.WithTTL[string, referential.Line](12*time.Hour),
+		ttlcache.WithCapacity[string, referential.Line](2000),
+	)
 	StopIdForDirectionCache = ttlcache.New[StopCacheKey, utils.StopId](
 		ttlcache.WithTTL[StopCacheKey, utils.StopId](12*time.Hour),
 		ttlcache.WithCapacity[StopCacheKey, utils.StopId](1000),
 	)
+	// StopMonitoringCache holds the latest stop visits of each monitoring ref for a few seconds
+	StopMonitoringCache = newVisitsCache(env.IDFM_TIMINGS_CACHE_TTL)
+	// StaleStopMonitoringCache keeps the last stop visits of each monitoring ref longer, to be served when saving quota
+	StaleStopMonitoringCache = newVisitsCache(env.IDFM_TIMINGS_STALE_TTL)
 )
 
+// newVisitsCache builds a cache of stop visits by monitoring ref. Hits do not extend the TTL, so that visits are
+// fetched again once it elapses, however often the monitoring ref is requested.
+func newVisitsCache(ttl time.Duration) *ttlcache.Cache[string, []siri.MonitoredStopVisit] {
+	return ttlcache.New[string, []siri.MonitoredStopVisit](
+		ttlcache.WithTTL[string, []siri.MonitoredStopVisit](ttl),
+		ttlcache.WithCapacity[string, []siri.MonitoredStopVisit](1000),
+		ttlcache.WithDisableTouchOnHit[string, []siri.MonitoredStopVisit](),
+	)
+}
+
 func registerCacheSizeMetric[K comparable, V any](cacheType string, cache *ttlcache.Cache[K, V]) {
 	promauto.NewGaugeFunc(prometheus.GaugeOpts{
 		Namespace: "idfm",
@@ -99,21 +121,39 @@ func registerCacheEvictionsMetric[K comparable, V any](cacheType string, cache *
 
 func InitCache() {
 	go TypeAndNumberToLineNameCache.Start()
+	go LineByIdCache.Start()
 	go StopIdForDirectionCache.Start()
+	go StopMonitoringCache.Start()
+	go StaleStopMonitoringCache.Start()
 
 	// Prometheus metrics
 	registerCacheSizeMetric("stops", StopIdForDirectionCache)
 	registerCacheSizeMetric("lines", TypeAndNumberToLineNameCache)
+	registerCacheSizeMetric("line_details", LineByIdCache)
+	registerCacheSizeMetric("stop_monitoring", StopMonitoringCache)
+	registerCacheSizeMetric("stale_stop_monitoring", StaleStopMonitoringCache)
 
 	registerCacheHitMetric("stops", StopIdForDirectionCache)
 	registerCacheHitMetric("lines", TypeAndNumberToLineNameCache)
+	registerCacheHitMetric("line_details", LineByIdCache)
+	registerCacheHitMetric("stop_monitoring", StopMonitoringCache)
+	registerCacheHitMetric("stale_stop_monitoring", StaleStopMonitoringCache)
 
 	registerCacheMissMetric("stops", StopIdForDirectionCache)
 	registerCacheMissMetric("lines", TypeAndNumberToLineNameCache)
+	registerCacheMissMetric("line_details", LineByIdCache)
+	registerCacheMissMetric("stop_monitoring", StopMonitoringCache)
+	registerCacheMissMetric("stale_stop_monitoring", StaleStopMonitoringCache)
 
 	registerCacheInsertionsMetric("stops", StopIdForDirectionCache)
 	registerCacheInsertionsMetric("lines", TypeAndNumberToLineNameCache)
+	registerCacheInsertionsMetric("line_details", LineByIdCache)
+	registerCacheInsertionsMetric("stop_monitoring", StopMonitoringCache)
+	registerCacheInsertionsMetric("stale_stop_monitoring", StaleStopMonitoringCache)
 
 	registerCacheEvictionsMetric("stops", StopIdForDirectionCache)
 	registerCacheEvictionsMetric("lines", TypeAndNumberToLineNameCache)
+	registerCacheEvictionsMetric("line_details", LineByIdCache)
+	registerCacheEvictionsMetric("stop_monitoring", StopMonitoringCache)
+	registerCacheEvictionsMetric("stale_stop_monitoring", StaleStopMonitoringCache)
 }
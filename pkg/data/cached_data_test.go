@@ -0,0 +1,25 @@
+package data
+
+import (
+	"github.com/jellydator/ttlcache/v3"
+	"idfm/pkg/internal/siri"
+	"testing"
+	"time"
+)
+
+func TestVisitsCacheExpiresDespiteHits(t *testing.T) {
+	ttl := 50 * time.Millisecond
+	cache := newVisitsCache(ttl)
+	cache.Set("STIF:StopPoint:Q:473921:", []siri.MonitoredStopVisit{{ItemIdentifier: "1"}}, ttlcache.DefaultTTL)
+
+	// Polling more often than the TTL must not keep the visits forever
+	deadline := time.Now().Add(3 * ttl)
+	for time.Now().Before(deadline) {
+		cache.Get("STIF:StopPoint:Q:473921:")
+		time.Sleep(ttl / 5)
+	}
+
+	if item := cache.Get("STIF:StopPoint:Q:473921:"); item != nil && !item.IsExpired() {
+		t.Errorf("visits still served %s after they were cached, with a TTL of %s", 3*ttl, ttl)
+	}
+}
@@ -0,0 +1,26 @@
+package data
+
+import (
+	"idfm/pkg/env"
+	"idfm/pkg/internal/direction"
+	"idfm/pkg/internal/referential"
+)
+
+// InitReferential loads the offline referential when dataset files are configured, and refreshes it in the background
+func InitReferential() error {
+	if !referential.Enabled() {
+		return nil
+	}
+
+	if err := referential.Load(); err != nil {
+		return err
+	}
+
+	go referential.Refresh(env.IDFM_REFERENTIAL_REFRESH)
+	return nil
+}
+
+// InitDirections loads the direction rules of the configured directions file
+func InitDirections() error {
+	return direction.Load()
+}
@@ -1,7 +1,143 @@
 package env
 
-import "os"
+import (
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
 
 var (
 	IDFM_API_KEY = os.Getenv("IDFM_API_KEY")
+	// IDFM_API_KEYS lists every PRIM API key, from the comma-separated IDFM_API_KEYS and from IDFM_API_KEY
+	IDFM_API_KEYS = getList("IDFM_API_KEYS", IDFM_API_KEY)
+	// IDFM_API_KEY_STRATEGY is how stop monitoring calls are spread across keys: "round-robin" or "quota"
+	IDFM_API_KEY_STRATEGY = getString("IDFM_API_KEY_STRATEGY", "round-robin")
+	// IDFM_API_KEY_BENCH is how long a key rejected by PRIM (401, 403 or 429) is left aside
+	IDFM_API_KEY_BENCH = getDuration("IDFM_API_KEY_BENCH", 5*time.Minute)
+	// IDFM_ALLOW_CLIENT_KEYS lets clients send their own PRIM API key, making the server keys optional
+	IDFM_ALLOW_CLIENT_KEYS = getBool("IDFM_ALLOW_CLIENT_KEYS", false)
+
+	// IDFM_OPENDATA_URL is the base URL of the opendata portal serving the lines and stops referential
+	IDFM_OPENDATA_URL = getBaseURL("IDFM_OPENDATA_URL", "https://data.iledefrance-mobilites.fr")
+	// IDFM_PRIM_URL is the base URL of the PRIM marketplace serving real-time stop monitoring
+	IDFM_PRIM_URL = getBaseURL("IDFM_PRIM_URL", "https://prim.iledefrance-mobilites.fr")
+
+	// IDFM_OPENDATA_TIMEOUT bounds each request attempt to the opendata portal
+	IDFM_OPENDATA_TIMEOUT = getDuration("IDFM_OPENDATA_TIMEOUT", 10*time.Second)
+	// IDFM_PRIM_TIMEOUT bounds each request attempt to PRIM
+	IDFM_PRIM_TIMEOUT = getDuration("IDFM_PRIM_TIMEOUT", 5*time.Second)
+	// IDFM_UPSTREAM_RETRIES is the number of retries of a transient upstream failure
+	IDFM_UPSTREAM_RETRIES = getInt("IDFM_UPSTREAM_RETRIES", 2)
+	// IDFM_BREAKER_THRESHOLD is the number of consecutive upstream failures opening the circuit breaker
+	IDFM_BREAKER_THRESHOLD = getInt("IDFM_BREAKER_THRESHOLD", 5)
+	// IDFM_BREAKER_COOLDOWN is how long an open circuit breaker fails fast before letting a probe through
+	IDFM_BREAKER_COOLDOWN = getDuration("IDFM_BREAKER_COOLDOWN", 30*time.Second)
+
+	// IDFM_LINES_DATASET_FILE is a JSON or CSV export of the referentiel-des-lignes dataset, used instead of the online referential
+	IDFM_LINES_DATASET_FILE = os.Getenv("IDFM_LINES_DATASET_FILE")
+	// IDFM_STOPS_DATASET_FILE is a JSON or CSV export of the arrets-lignes dataset, used instead of the online referential
+	IDFM_STOPS_DATASET_FILE = os.Getenv("IDFM_STOPS_DATASET_FILE")
+	// IDFM_TRIPS_DATASET_FILE is the trips.txt file of the IDFM GTFS feed, giving the termini of each line
+	IDFM_TRIPS_DATASET_FILE = os.Getenv("IDFM_TRIPS_DATASET_FILE")
+	// IDFM_DIRECTIONS_FILE is a JSON file of per-line direction rules, overriding the termini of the trips file
+	IDFM_DIRECTIONS_FILE = os.Getenv("IDFM_DIRECTIONS_FILE")
+	// IDFM_REFERENTIAL_REFRESH is how often the dataset files are reloaded (0 disables reloading)
+	IDFM_REFERENTIAL_REFRESH = getDuration("IDFM_REFERENTIAL_REFRESH", time.Hour)
+
+	// IDFM_LISTING_CAP is the maximum number of records retrieved when listing lines or stops
+	IDFM_LISTING_CAP = getInt("IDFM_LISTING_CAP", 5000)
+	// IDFM_DEFAULT_OPERATORS lists the operators lines are looked up in when none is requested, "*" meaning any operator
+	IDFM_DEFAULT_OPERATORS = getListOrDefault("IDFM_DEFAULT_OPERATORS", "RATP", "SNCF")
+
+	// IDFM_TIMINGS_WORKERS is the maximum number of stop IDs requested in parallel for a single timings request
+	IDFM_TIMINGS_WORKERS = getInt("IDFM_TIMINGS_WORKERS", 4)
+	// IDFM_TIMINGS_CACHE_TTL is how long stop monitoring responses are reused (0 disables the cache)
+	IDFM_TIMINGS_CACHE_TTL = getDuration("IDFM_TIMINGS_CACHE_TTL", 5*time.Second)
+	// IDFM_TIMINGS_STALE_TTL is how long stop monitoring responses are kept to be served when saving quota
+	IDFM_TIMINGS_STALE_TTL = getDuration("IDFM_TIMINGS_STALE_TTL", 10*time.Minute)
+
+	// IDFM_QUOTA_SOFT_LIMIT is the daily number of stop monitoring calls per key after which stale timings are preferred (0 disables it)
+	IDFM_QUOTA_SOFT_LIMIT = getInt("IDFM_QUOTA_SOFT_LIMIT", 0)
+	// IDFM_QUOTA_HARD_LIMIT is the daily number of stop monitoring calls per key after which PRIM is no longer called (0 disables it)
+	IDFM_QUOTA_HARD_LIMIT = getInt("IDFM_QUOTA_HARD_LIMIT", 0)
 )
+
+// getString reads a string from the environment
+func getString(name string, defaultValue string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// getBool reads a boolean such as "true" from the environment
+func getBool(name string, defaultValue bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	boolean, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s \"%s\" is not a valid boolean: %s", name, value, err)
+	}
+	return boolean
+}
+
+// getList reads a comma-separated list from the environment, followed by the extra values, without blanks or duplicates
+func getList(name string, extraValues ...string) []string {
+	var list []string
+	for _, value := range append(strings.Split(os.Getenv(name), ","), extraValues...) {
+		value = strings.TrimSpace(value)
+		if value != "" && !slices.Contains(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// getListOrDefault reads a comma-separated list from the environment like getList, or returns the default values when unset
+func getListOrDefault(name string, defaultValues ...string) []string {
+	if list := getList(name); len(list) > 0 {
+		return list
+	}
+	return defaultValues
+}
+
+// getBaseURL reads a base URL from the environment, without its trailing slash
+func getBaseURL(name string, defaultValue string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = defaultValue
+	}
+	return strings.TrimRight(value, "/")
+}
+
+// getDuration reads a duration such as "5s" from the environment
+func getDuration(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s \"%s\" is not a valid duration: %s", name, value, err)
+	}
+	return duration
+}
+
+// getInt reads an integer from the environment
+func getInt(name string, defaultValue int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s \"%s\" is not a valid integer: %s", name, value, err)
+	}
+	return number
+}
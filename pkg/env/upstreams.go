@@ -0,0 +1,40 @@
+package env
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// CheckUpstreams validates the configured upstream base URLs and makes sure they can be reached
+func CheckUpstreams() error {
+	upstreams := []struct {
+		name    string
+		baseURL string
+	}{
+		{"IDFM_OPENDATA_URL", IDFM_OPENDATA_URL},
+		{"IDFM_PRIM_URL", IDFM_PRIM_URL},
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	for _, upstream := range upstreams {
+		parsed, err := url.Parse(upstream.baseURL)
+		if err != nil {
+			return fmt.Errorf("%s \"%s\" is not a valid URL: %w", upstream.name, upstream.baseURL, err)
+		}
+		if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%s \"%s\" must be an absolute http(s) URL", upstream.name, upstream.baseURL)
+		}
+
+		// Any HTTP response, whatever its status, means the upstream is reachable
+		resp, err := client.Get(upstream.baseURL)
+		if err != nil {
+			return fmt.Errorf("%s \"%s\" is not reachable: %w", upstream.name, upstream.baseURL, err)
+		}
+		resp.Body.Close()
+	}
+
+	return nil
+}
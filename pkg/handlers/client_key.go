@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"idfm/pkg/env"
+	"idfm/pkg/internal/apikey"
+	"idfm/pkg/internal/quota"
+	"idfm/pkg/internal/utils"
+)
+
+// ClientKeyHeader is the request header through which clients may provide their own PRIM API key
+const ClientKeyHeader = apikey.ClientKeyHeader
+
+// KeyFingerprint identifies a client API key without disclosing it, such as to rate limit it
+func KeyFingerprint(clientKey string) string {
+	return quota.Fingerprint(clientKey)
+}
+
+// ClientKeyMiddleware makes the PRIM API key provided by the client available to the stop monitoring calls.
+// The key is only carried by the request context: it is neither logged, cached nor echoed back.
+func ClientKeyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientKey := c.GetHeader(apikey.ClientKeyHeader)
+		if clientKey == "" {
+			c.Next()
+			return
+		}
+
+		if !env.IDFM_ALLOW_CLIENT_KEYS {
+			handleGinError(c, &utils.RequestError{Message: "Client API keys are not accepted by this server"})
+			c.Abort()
+			return
+		}
+
+		c.Request = c.Request.WithContext(apikey.WithClientKey(c.Request.Context(), clientKey))
+		c.Next()
+	}
+}
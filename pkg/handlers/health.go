@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"idfm/pkg/internal/apikey"
+	"net/http"
+)
+
+func HealthHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys := apikey.Healths()
+
+		// Without server keys, every client brings its own
+		status := "degraded"
+		if len(keys) == 0 {
+			status = "healthy"
+		}
+		for _, key := range keys {
+			if key.Healthy {
+				status = "healthy"
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": status,
+			"keys":   keys,
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"idfm/pkg/internal/line"
+	"idfm/pkg/internal/time"
+	"idfm/pkg/internal/utils"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// maxBoardStops is the number of stop IDs whose departures are requested for a board
+const maxBoardStops = 20
+
+// boardTimings are the timings of the stops of a board, along with the display of the board
+type boardTimings struct {
+	time.Timings
+	lines   map[string]line.Details
+	perLine int
+	// keep tells whether the departures of a line are shown, nil showing every line
+	keep func(details line.Details) bool
+}
+
+// IDFMBoardHandler returns the next departures of every line at a stop, grouped by line and direction
+func (s *Server) IDFMBoardHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timings, err := s.getBoardTimings(c)
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+		setFailedRefsHeader(c, timings.Failed)
+
+		board := time.GroupByLine(timings.Visits, timings.lines, timings.perLine)
+		if timings.keep != nil {
+			board = filterDepartures(board, timings.keep)
+		}
+
+		c.JSON(http.StatusOK, board)
+	}
+}
+
+// IDFMBoardHandlerV2 returns the next departures of every line at a stop, grouped by line and direction
+func (s *Server) IDFMBoardHandlerV2() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timings, err := s.getBoardTimings(c)
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		board := time.GroupDeparturesByLine(timings.Visits, timings.lines, timings.perLine)
+		if timings.keep != nil {
+			board = filterDepartures(board, timings.keep)
+		}
+
+		c.JSON(http.StatusOK, boardResponse{Lines: board, Failures: failures(timings.Failed)})
+	}
+}
+
+// getBoardTimings resolves the stop name of a board request, and retrieves the timings of the stop
+func (s *Server) getBoardTimings(c *gin.Context) (boardTimings, error) {
+	// perLine limits the departures of each line instead
+	if c.Query("limit") != "" {
+		return boardTimings{}, &utils.RequestError{Message: "Boards take perLine rather than limit"}
+	}
+	perLine, err := parseLimit(c.Query("perLine"), 3, 10)
+	if err != nil {
+		return boardTimings{}, err
+	}
+
+	selection, err := parseSelection(c)
+	if err != nil {
+		return boardTimings{}, err
+	}
+
+	var stopIDs []utils.StopId
+	if stopID, isRef := time.ParseMonitoringRef(c.Param("stop")); isRef {
+		stopIDs = []utils.StopId{stopID}
+	} else {
+		stopIDs, err = s.stops.GetStopIDsByName(c.Param("stop"), c.Query("town"))
+		if err != nil {
+			return boardTimings{}, err
+		}
+	}
+
+	timings := boardTimings{perLine: perLine}
+
+	// The stops left out are reported along with the failures
+	var skipped []time.FailedRef
+	if len(stopIDs) > maxBoardStops {
+		skipped = time.Skipped(stopIDs[maxBoardStops:])
+		stopIDs = stopIDs[:maxBoardStops]
+	}
+
+	timings.Timings, err = s.timings.GetAllTimings(c.Request.Context(), stopIDs)
+	if err != nil {
+		return boardTimings{}, err
+	}
+
+	viaFailed, err := s.selectVia(c, "", timings.Visits, &selection)
+	if err != nil {
+		return boardTimings{}, err
+	}
+	timings.Failed = append(timings.Failed, viaFailed...)
+	timings.Failed = append(timings.Failed, skipped...)
+	timings.Visits = selection.Apply(timings.Visits)
+
+	timings.lines, err = s.lines.GetLineDetailsByIds(time.LineIds(timings.Visits))
+	if err != nil {
+		return boardTimings{}, err
+	}
+
+	if linesParam := c.Query("lines"); linesParam != "" {
+		// Lines are designated by IDFM line ID, such as "C01742", or by name, such as "A"
+		wanted := strings.Split(linesParam, ",")
+		timings.keep = func(details line.Details) bool {
+			return slices.ContainsFunc(wanted, func(wantedLine string) bool {
+				wantedLine = strings.TrimPrefix(strings.TrimSpace(wantedLine), "IDFM:")
+				return wantedLine == details.ID || strings.EqualFold(wantedLine, details.Name)
+			})
+		}
+	}
+	return timings, nil
+}
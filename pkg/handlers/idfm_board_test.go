@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+	"idfm/pkg/internal/siri"
+	"idfm/pkg/internal/time"
+	"idfm/pkg/internal/utils"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBoardHandler(t *testing.T) {
+	server, _, _ := rerA()
+
+	var board []time.LineDepartures
+	recorder := serve(server, "/boards/Auber", &board)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	// One group for each direction of the line
+	if len(board) != 2 || board[0].Line.ID != "C01742" || board[1].Line.ID != "C01742" || board[0].Direction == board[1].Direction {
+		t.Errorf("board = %+v, want both directions of C01742", board)
+	}
+}
+
+func TestBoardHandlerSkipsStops(t *testing.T) {
+	server, stops, timings := rerA()
+
+	var stopIDs []utils.StopId
+	for index := range maxBoardStops + 5 {
+		stopID := fmt.Sprint(500000 + index)
+		stopIDs = append(stopIDs, utils.StopId{Id: stopID, Type: utils.Point})
+		timings.visits[stopID] = []siri.MonitoredStopVisit{visit("C01743", stopID, "A", "Robinson", index)}
+	}
+	stops.stopIDs["Châtelet"] = stopIDs
+
+	var response boardResponse
+	recorder := serve(server, "/v2/boards/Châtelet", &response)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+
+	if len(timings.requested) != maxBoardStops {
+		t.Errorf("requested %d stop IDs, want %d", len(timings.requested), maxBoardStops)
+	}
+	if len(response.Failures) != 5 {
+		t.Fatalf("failures = %+v, want the 5 stop IDs left out", response.Failures)
+	}
+	for _, failure := range response.Failures {
+		if failure.Reason != "skipped" {
+			t.Errorf("failure = %+v, want skipped", failure)
+		}
+	}
+
+	v1 := serve(server, "/boards/Châtelet", nil)
+	if header := v1.Header().Get("X-Failed-Monitoring-Refs"); strings.Count(header, "=skipped") != 5 {
+		t.Errorf("X-Failed-Monitoring-Refs = %s, want the 5 stop IDs left out", header)
+	}
+}
+
+func TestBoardHandlerLines(t *testing.T) {
+	server, _, timings := rerA()
+	timings.visits["473921"] = append(timings.visits["473921"], visit("C01743", "473921", "A", "Robinson", 2))
+
+	var board []time.LineDepartures
+	serve(server, "/boards/Auber?lines=B", &board)
+	if len(board) != 1 || board[0].Line.ID != "C01743" {
+		t.Errorf("board = %+v, want C01743 only", board)
+	}
+}
+
+func TestBoardHandlerRejectsLimit(t *testing.T) {
+	server, _, _ := rerA()
+
+	recorder := serve(server, "/boards/Auber?limit=2", nil)
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "perLine") {
+		t.Errorf("status = %d, body = %s, want a request error pointing to perLine", recorder.Code, recorder.Body)
+	}
+}
@@ -2,27 +2,103 @@ package handlers
 
 import (
 	"github.com/gin-gonic/gin"
-	"idfm/pkg/internal/line"
 	"net/http"
 )
 
-func IDFMLineHandler() gin.HandlerFunc {
+func (s *Server) IDFMLineHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		transportType, err := validateTransportType(c.Param("type"))
 		if err != nil {
 			handleGinError(c, err)
 			return
 		}
+		submode, err := validateOptionalSubmode(c.Query("submode"))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
 		transportId := c.Param("id")
 
 		operator := c.Query("operator")
 
-		lineID, err := line.GetLineDetailsOrCache(transportType, transportId, operator)
+		details, err := s.lines.GetLine(transportType, submode, transportId, operator)
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, details)
+	}
+}
+
+// IDFMLineDirectionsHandler returns the directions of a line along with their termini
+func (s *Server) IDFMLineDirectionsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		transportType, err := validateTransportType(c.Param("type"))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+		submode, err := validateOptionalSubmode(c.Query("submode"))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		directions, err := s.lines.GetDirections(transportType, submode, c.Param("id"), c.Query("operator"))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, directions)
+	}
+}
+
+func (s *Server) IDFMLinesHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		transportType, err := validateOptionalTransportType(c.Query("type"))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		submode, err := validateOptionalSubmode(c.Query("submode"))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		lines, err := s.lines.ListLines(transportType, submode, c.Query("operator"))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, lines)
+	}
+}
+
+func (s *Server) IDFMOperatorsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		transportType, err := validateOptionalTransportType(c.Query("type"))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		submode, err := validateOptionalSubmode(c.Query("submode"))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		operators, err := s.lines.ListOperators(transportType, submode)
 		if err != nil {
 			handleGinError(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"id": lineID})
+		c.JSON(http.StatusOK, operators)
 	}
 }
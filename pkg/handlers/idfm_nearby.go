@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"idfm/pkg/internal/line"
+	"idfm/pkg/internal/stop"
+	"idfm/pkg/internal/time"
+	"idfm/pkg/internal/utils"
+	"net/http"
+)
+
+// maxDepartureStops is the number of nearest stops whose departures are requested
+const maxDepartureStops = 10
+
+type nearbyResponse struct {
+	Stops      []stop.SearchResult   `json:"stops"`
+	Departures []time.LineDepartures `json:"departures,omitempty"`
+}
+
+func (s *Server) IDFMNearbyHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lat, err := parseCoordinate("lat", c.Query("lat"), 90)
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+		lon, err := parseCoordinate("lon", c.Query("lon"), 180)
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		radius, err := parseRadius(c.Query("radius"), 500, 2000)
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		transportType, err := validateOptionalTransportType(c.Query("type"))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		submode, err := validateOptionalSubmode(c.Query("submode"))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		limit, err := parseLimit(c.Query("limit"), 20, 100)
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		stops, err := s.stops.Nearby(stop.NearbyQuery{
+			Lat:         lat,
+			Lon:         lon,
+			Radius:      radius,
+			LineType:    transportType,
+			LineSubmode: submode,
+			Limit:       limit,
+		})
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		response := nearbyResponse{Stops: stops}
+		if response.Stops == nil {
+			response.Stops = []stop.SearchResult{}
+		}
+
+		if c.Query("departures") != "true" || len(stops) == 0 {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		perLine, err := parseLimit(c.Query("perLine"), 3, 10)
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		stopIDs := make([]utils.StopId, 0, min(len(stops), maxDepartureStops))
+		for _, nearbyStop := range stops[:min(len(stops), maxDepartureStops)] {
+			stopIDs = append(stopIDs, utils.StopId{Id: nearbyStop.StopID, Type: nearbyStop.Type})
+		}
+
+		allTimings, err := s.timings.GetAllTimings(c.Request.Context(), stopIDs)
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+		setFailedRefsHeader(c, allTimings.Failed)
+
+		lines, err := s.lines.GetLineDetailsByIds(time.LineIds(allTimings.Visits))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		response.Departures = time.GroupByLine(allTimings.Visits, lines, perLine)
+		if transportType != "" || submode != "" {
+			response.Departures = filterDepartures(response.Departures, func(details line.Details) bool {
+				return (transportType == "" || details.Type == transportType) && (submode == "" || details.Submode == submode)
+			})
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"idfm/pkg/internal/stop"
+	"net/http"
+)
+
+func (s *Server) IDFMStopSearchHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		transportType, err := validateOptionalTransportType(c.Query("type"))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		submode, err := validateOptionalSubmode(c.Query("submode"))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		limit, err := parseLimit(c.Query("limit"), 20, 100)
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		lineID, err := s.resolveLineFilter(transportType, submode, c.Query("line"), c.Query("operator"))
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		results, err := s.stops.Search(stop.SearchQuery{
+			Text:        c.Query("q"),
+			LineType:    transportType,
+			LineSubmode: submode,
+			LineId:      lineID,
+			Limit:       limit,
+		})
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, results)
+	}
+}
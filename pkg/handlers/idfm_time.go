@@ -2,53 +2,227 @@ package handlers
 
 import (
 	"github.com/gin-gonic/gin"
-	"idfm/pkg/internal/line"
-	"idfm/pkg/internal/stop"
+	"idfm/pkg/internal/siri"
 	"idfm/pkg/internal/time"
 	"idfm/pkg/internal/utils"
 	"net/http"
 )
 
-func IDFMTimeHandler() gin.HandlerFunc {
+// lineTimings are the timings of a stop of a line, along with the filters of the request
+type lineTimings struct {
+	time.Timings
+	lineID    string
+	stopIDs   []utils.StopId
+	stopName  string
+	direction string
+	platform  string
+	selection time.Selection
+}
+
+// stopTimings are the timings of a stop given by ID, along with the filters of the request
+type stopTimings struct {
+	time.Timings
+	lineID    string
+	direction string
+	platform  string
+	selection time.Selection
+}
+
+func (s *Server) IDFMTimeHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		transportType, err := validateTransportType(c.Param("type"))
+		timings, err := s.getLineTimings(c)
 		if err != nil {
 			handleGinError(c, err)
 			return
 		}
-		transportId := c.Param("id")
-		stopName := c.Param("stop")
+		setFailedRefsHeader(c, timings.Failed)
+
+		visits := time.FindVisits(timings.Visits, timings.lineID, timings.stopIDs, timings.direction, timings.platform, timings.selection)
+		s.rememberStopID(timings, visits)
 
-		operator := c.Query("operator")
-		dir := c.Query("direction")
-		platform := c.Query("platform")
+		c.JSON(http.StatusOK, time.ToResults(visits))
+	}
+}
 
-		lineID, err := line.GetLineDetailsOrCache(transportType, transportId, operator)
+func (s *Server) IDFMTimeHandlerV2() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timings, err := s.getLineTimings(c)
 		if err != nil {
 			handleGinError(c, err)
 			return
 		}
 
-		var stopIDs []utils.StopId
-		stopID, exists := stop.GetCachedStopIDsForDirection(lineID, stopName, dir, platform)
-		if exists {
-			stopIDs = []utils.StopId{stopID}
-		} else {
-			stopIDs, err = stop.GetStopIDs(lineID, stopName)
-			if err != nil {
-				handleGinError(c, err)
-				return
-			}
-		}
+		visits := time.FindVisits(timings.Visits, timings.lineID, timings.stopIDs, timings.direction, timings.platform, timings.selection)
+		s.rememberStopID(timings, visits)
 
-		allTimings, err := time.GetAllTimings(stopIDs)
+		c.JSON(http.StatusOK, newDeparturesResponse(visits, timings.Failed))
+	}
+}
+
+// IDFMStopPointTimeHandler returns the timings of a stop point, given by ID or monitoring ref
+func (s *Server) IDFMStopPointTimeHandler() gin.HandlerFunc {
+	return s.stopTimeHandler(utils.Point)
+}
+
+// IDFMStopAreaTimeHandler returns the timings of a stop area, given by ID or monitoring ref
+func (s *Server) IDFMStopAreaTimeHandler() gin.HandlerFunc {
+	return s.stopTimeHandler(utils.Area)
+}
+
+func (s *Server) stopTimeHandler(stopType utils.StopType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timings, err := s.getStopTimings(c, stopType)
 		if err != nil {
 			handleGinError(c, err)
 			return
 		}
+		setFailedRefsHeader(c, timings.Failed)
 
-		results := time.FindResults(allTimings, lineID, stopIDs, stopName, dir, platform)
+		results := time.FilterResults(timings.Visits, timings.lineID, timings.direction, timings.platform, timings.selection)
 
 		c.JSON(http.StatusOK, results)
 	}
 }
+
+// IDFMStopPointTimeHandlerV2 returns the departures of a stop point, given by ID or monitoring ref
+func (s *Server) IDFMStopPointTimeHandlerV2() gin.HandlerFunc {
+	return s.stopTimeHandlerV2(utils.Point)
+}
+
+// IDFMStopAreaTimeHandlerV2 returns the departures of a stop area, given by ID or monitoring ref
+func (s *Server) IDFMStopAreaTimeHandlerV2() gin.HandlerFunc {
+	return s.stopTimeHandlerV2(utils.Area)
+}
+
+func (s *Server) stopTimeHandlerV2(stopType utils.StopType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timings, err := s.getStopTimings(c, stopType)
+		if err != nil {
+			handleGinError(c, err)
+			return
+		}
+
+		visits := time.FilterVisits(timings.Visits, timings.lineID, timings.direction, timings.platform, timings.selection)
+
+		c.JSON(http.StatusOK, newDeparturesResponse(visits, timings.Failed))
+	}
+}
+
+// getLineTimings resolves the line and stop names of a timings request, and retrieves the timings of the stop
+func (s *Server) getLineTimings(c *gin.Context) (lineTimings, error) {
+	transportType, err := validateTransportType(c.Param("type"))
+	if err != nil {
+		return lineTimings{}, err
+	}
+	submode, err := validateOptionalSubmode(c.Query("submode"))
+	if err != nil {
+		return lineTimings{}, err
+	}
+	transportId := c.Param("id")
+
+	selection, err := parseSelection(c)
+	if err != nil {
+		return lineTimings{}, err
+	}
+
+	timings := lineTimings{
+		selection: selection,
+		stopName:  c.Param("stop"),
+		direction: c.Query("direction"),
+		platform:  c.Query("platform"),
+	}
+
+	timings.lineID, err = s.lines.GetLineDetailsOrCache(transportType, submode, transportId, c.Query("operator"))
+	if err != nil {
+		return lineTimings{}, err
+	}
+
+	timings.direction, err = s.resolveDirection(timings.lineID, timings.direction)
+	if err != nil {
+		return lineTimings{}, err
+	}
+
+	stopID, exists := s.stops.GetCachedStopIDsForDirection(timings.lineID, timings.stopName, timings.direction, timings.platform)
+	if exists {
+		timings.stopIDs = []utils.StopId{stopID}
+	} else {
+		timings.stopIDs, err = s.stops.GetStopIDs(timings.lineID, timings.stopName)
+		if err != nil {
+			return lineTimings{}, err
+		}
+	}
+
+	timings.Timings, err = s.timings.GetAllTimings(c.Request.Context(), timings.stopIDs)
+	if err != nil {
+		return lineTimings{}, err
+	}
+
+	viaFailed, err := s.selectVia(c, timings.lineID, timings.Visits, &timings.selection)
+	if err != nil {
+		return lineTimings{}, err
+	}
+	timings.Failed = append(timings.Failed, viaFailed...)
+	return timings, nil
+}
+
+// rememberStopID caches the stop ID the visits were found at, so that the next requests in that direction or at that
+// platform only request that stop ID
+func (s *Server) rememberStopID(timings lineTimings, visits []siri.MonitoredStopVisit) {
+	if timings.direction == "" && timings.platform == "" {
+		return
+	}
+	if stopID, found := time.VisitedStopId(visits, timings.stopIDs); found {
+		s.stops.CacheStopIDForDirection(timings.lineID, timings.stopName, timings.direction, timings.platform, stopID)
+	}
+}
+
+// getStopTimings resolves the stop ID and the optional line of a timings request, and retrieves the timings of the stop
+func (s *Server) getStopTimings(c *gin.Context, stopType utils.StopType) (stopTimings, error) {
+	stopID, err := time.ParseStopId(stopType, c.Param("id"))
+	if err != nil {
+		return stopTimings{}, err
+	}
+
+	transportType, err := validateOptionalTransportType(c.Query("type"))
+	if err != nil {
+		return stopTimings{}, err
+	}
+
+	submode, err := validateOptionalSubmode(c.Query("submode"))
+	if err != nil {
+		return stopTimings{}, err
+	}
+
+	selection, err := parseSelection(c)
+	if err != nil {
+		return stopTimings{}, err
+	}
+
+	timings := stopTimings{
+		selection: selection,
+		direction: c.Query("direction"),
+		platform:  c.Query("platform"),
+	}
+
+	timings.lineID, err = s.resolveLineFilter(transportType, submode, c.Query("line"), c.Query("operator"))
+	if err != nil {
+		return stopTimings{}, err
+	}
+
+	timings.direction, err = s.resolveDirection(timings.lineID, timings.direction)
+	if err != nil {
+		return stopTimings{}, err
+	}
+
+	timings.Timings, err = s.timings.GetAllTimings(c.Request.Context(), []utils.StopId{stopID})
+	if err != nil {
+		return stopTimings{}, err
+	}
+
+	viaFailed, err := s.selectVia(c, timings.lineID, timings.Visits, &timings.selection)
+	if err != nil {
+		return stopTimings{}, err
+	}
+	timings.Failed = append(timings.Failed, viaFailed...)
+	return timings, nil
+}
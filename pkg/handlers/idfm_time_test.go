@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"encoding/json"
+	"idfm/pkg/data"
+	"idfm/pkg/internal/siri"
+	"idfm/pkg/internal/time"
+	"idfm/pkg/internal/utils"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	stdtime "time"
+)
+
+func TestTimeHandler(t *testing.T) {
+	server, _, _ := rerA()
+
+	var results []time.Result
+	recorder := serve(server, "/timings/rail/A/Auber", &results)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	// Visits of both stop IDs, soonest first
+	if len(results) != 2 || results[0].Dest != "Cergy" || results[1].Dest != "Chessy" {
+		t.Errorf("results = %+v, want Cergy then Chessy", results)
+	}
+}
+
+func TestTimeHandlerDirection(t *testing.T) {
+	server, stops, timings := rerA()
+
+	var results []time.Result
+	recorder := serve(server, "/timings/rail/A/Auber?direction=A", &results)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	if len(results) != 1 || results[0].Dest != "Chessy" {
+		t.Errorf("results = %+v, want Chessy only", results)
+	}
+
+	// The stop ID the direction was found at is remembered, and the only one requested next time
+	key := data.StopCacheKey{LineId: "C01742", StopName: "Auber", Direction: "A"}
+	if cached := stops.cached[key]; cached.Id != "473921" {
+		t.Fatalf("cached stop ID = %+v, want 473921", cached)
+	}
+
+	timings.requested = nil
+	serve(server, "/timings/rail/A/Auber?direction=A", &results)
+	if len(timings.requested) != 1 || timings.requested[0].Id != "473921" {
+		t.Errorf("requested = %+v, want 473921 only", timings.requested)
+	}
+	if len(results) != 1 || results[0].Dest != "Chessy" {
+		t.Errorf("results = %+v, want Chessy only", results)
+	}
+}
+
+func TestTimeHandlerTerminusDirection(t *testing.T) {
+	server, _, _ := rerA()
+
+	var results []time.Result
+	recorder := serve(server, "/timings/rail/A/Auber?direction=Cergy", &results)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	if len(results) != 1 || results[0].Dest != "Cergy" {
+		t.Errorf("results = %+v, want Cergy only", results)
+	}
+
+	if recorder := serve(server, "/timings/rail/A/Auber?direction=Nowhere", nil); recorder.Code != http.StatusBadRequest {
+		t.Errorf("unknown terminus: status = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestTimeHandlerRequestErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		// want is a part of the response body
+		want string
+	}{
+		{"invalid type", "/timings/plane/A/Auber", "Invalid transport type"},
+		{"invalid submode", "/timings/rail/A/Auber?submode=rocket", "Invalid transport submode"},
+		{"unknown line", "/timings/rail/Z/Auber", "not found"},
+		{"ambiguous line", "/timings/bus/1/Auber", `"candidates":["C01371","C00001"]`},
+		{"unknown stop", "/timings/rail/A/Nowhere", "not found"},
+		{"invalid window", "/timings/rail/A/Auber?from=10&to=5", "Invalid time window"},
+		{"invalid stop point", "/timings/stoppoint/abc", "request error"},
+		{"line name without type", "/timings/stoppoint/473921?line=14", "along with type"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _, _ := rerA()
+			recorder := serve(server, test.path, nil)
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", recorder.Code, http.StatusBadRequest)
+			}
+			if !strings.Contains(recorder.Body.String(), test.want) {
+				t.Errorf("body = %s, want %s in it", recorder.Body, test.want)
+			}
+		})
+	}
+}
+
+func TestTimeHandlerV2Failures(t *testing.T) {
+	server, _, timings := rerA()
+	timings.failed = map[string]bool{"473922": true}
+
+	var response departuresResponse
+	recorder := serve(server, "/v2/timings/rail/A/Auber", &response)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	if len(response.Departures) != 1 || response.Departures[0].Destination.Name != "Chessy" {
+		t.Errorf("departures = %+v, want Chessy only", response.Departures)
+	}
+	want := time.FailedRef{MonitoringRef: "STIF:StopPoint:Q:473922:", Reason: "error"}
+	if len(response.Failures) != 1 || response.Failures[0] != want {
+		t.Errorf("failures = %+v, want %+v", response.Failures, want)
+	}
+}
+
+func TestStopPointTimeHandler(t *testing.T) {
+	server, _, timings := rerA()
+
+	var results []time.Result
+	recorder := serve(server, "/timings/stoppoint/473921", &results)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	if len(timings.requested) != 1 || timings.requested[0] != (utils.StopId{Id: "473921", Type: utils.Point}) {
+		t.Errorf("requested = %+v, want stop point 473921", timings.requested)
+	}
+	// Without a line, each result tells its line
+	if len(results) != 1 || results[0].Line != "C01742" {
+		t.Errorf("results = %+v, want one result of C01742", results)
+	}
+}
+
+func TestTimeHandlerTerminus(t *testing.T) {
+	server, _, timings := rerA()
+	// At a terminus, PRIM only gives the expected arrival time
+	arrival := visit("C01742", "473921", "A", "Chessy", 7)
+	call := &arrival.MonitoredVehicleJourney.MonitoredCall
+	call.ExpectedArrivalTime, call.ExpectedDepartureTime, call.AimedDepartureTime = call.ExpectedDepartureTime, stdtime.Time{}, stdtime.Time{}
+	timings.visits["473921"] = []siri.MonitoredStopVisit{arrival}
+
+	recorder := serve(server, "/timings/rail/A/Auber?direction=A", nil)
+	var results []map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &results); err != nil || len(results) != 1 {
+		t.Fatalf("body = %s, want one result", recorder.Body)
+	}
+	if results[0]["time"] != "7 mn" || results[0]["minutes"] != float64(7) {
+		t.Errorf("time = %v, minutes = %v, want 7 mn", results[0]["time"], results[0]["minutes"])
+	}
+	if delay, found := results[0]["delay"]; found {
+		t.Errorf("delay = %v, want it omitted", delay)
+	}
+}
+
+func TestTimeHandlerWindow(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"unbounded", "", []string{"Cergy", "Chessy"}},
+		{"to", "?to=4", []string{"Cergy"}},
+		{"to now", "?to=0", []string{}},
+		{"from", "?from=4", []string{"Chessy"}},
+		{"walk", "?walk=4&to=10", []string{"Chessy"}},
+		{"limit", "?limit=1", []string{"Cergy"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _, _ := rerA()
+
+			var results []time.Result
+			recorder := serve(server, "/timings/rail/A/Auber"+test.query, &results)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+			}
+			destinations := []string{}
+			for _, result := range results {
+				destinations = append(destinations, result.Dest)
+			}
+			if !slices.Equal(destinations, test.want) {
+				t.Errorf("destinations = %v, want %v", destinations, test.want)
+			}
+		})
+	}
+}
+
+func TestStopPointTimeHandlerFailures(t *testing.T) {
+	server, stops, timings := rerA()
+	// The via stop resolves to more stop IDs than requested, one of which fails
+	for _, stopID := range []string{"411301", "411302", "411303", "411304", "411305"} {
+		stops.stopIDs["La Défense"] = append(stops.stopIDs["La Défense"], utils.StopId{Id: stopID, Type: utils.Point})
+	}
+	timings.failed = map[string]bool{"411301": true}
+
+	recorder := serve(server, "/timings/stoppoint/473921?via=La%20D%C3%A9fense", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	want := "STIF:StopPoint:Q:411301:=error, STIF:StopPoint:Q:411305:=skipped"
+	if header := recorder.Header().Get("X-Failed-Monitoring-Refs"); header != want {
+		t.Errorf("X-Failed-Monitoring-Refs = %q, want %q", header, want)
+	}
+}
+
+func TestStopPointTimeHandlerLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"line ID", "?line=C01742", 1},
+		{"prefixed line ID", "?line=IDFM:C01742", 1},
+		{"line name with type", "?line=A&type=rail", 1},
+		{"other line", "?line=C01743", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _, _ := rerA()
+
+			var results []time.Result
+			recorder := serve(server, "/timings/stoppoint/473921"+test.query, &results)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+			}
+			if len(results) != test.want {
+				t.Errorf("got %d results, want %d", len(results), test.want)
+			}
+		})
+	}
+}
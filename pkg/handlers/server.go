@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"idfm/pkg/internal/direction"
+	"idfm/pkg/internal/line"
+	"idfm/pkg/internal/stop"
+	"idfm/pkg/internal/time"
+	"idfm/pkg/internal/utils"
+)
+
+// LineResolver resolves a line type and name to an IDFM line ID, and lists lines, their operators and directions
+type LineResolver interface {
+	GetLineDetailsOrCache(lineType string, submode string, lineId string, operator string) (string, error)
+	GetLine(lineType string, submode string, lineId string, operator string) (line.Details, error)
+	ListLines(lineType string, submode string, operator string) ([]line.Details, error)
+	GetLineDetailsByIds(lineIds []string) (map[string]line.Details, error)
+	ListOperators(lineType string, submode string) ([]line.Operator, error)
+	GetDirections(lineType string, submode string, lineId string, operator string) ([]direction.Direction, error)
+	MatchDirection(lineId string, value string) (string, error)
+}
+
+// StopResolver resolves a stop name on a line to its stop IDs, remembering the one of each direction and platform,
+// and searches stops by name or position
+type StopResolver interface {
+	GetCachedStopIDsForDirection(lineId string, stopName string, direction string, platform string) (utils.StopId, bool)
+	CacheStopIDForDirection(lineId string, stopName string, direction string, platform string, stopID utils.StopId)
+	GetStopIDs(lineId string, stopName string) ([]utils.StopId, error)
+	GetStopIDsByName(stopName string, town string) ([]utils.StopId, error)
+	GetStopIDsOnLines(stopName string, lineIds []string) ([]utils.StopId, error)
+	Search(query stop.SearchQuery) ([]stop.SearchResult, error)
+	Nearby(query stop.NearbyQuery) ([]stop.SearchResult, error)
+}
+
+// TimingsProvider retrieves the real-time visits of stop IDs
+type TimingsProvider interface {
+	GetAllTimings(ctx context.Context, stopIDs []utils.StopId) (time.Timings, error)
+}
+
+// Server builds the API handlers from the line, stop and timings implementations it receives
+type Server struct {
+	lines   LineResolver
+	stops   StopResolver
+	timings TimingsProvider
+}
+
+func NewServer(lines LineResolver, stops StopResolver, timings TimingsProvider) *Server {
+	return &Server{
+		lines:   lines,
+		stops:   stops,
+		timings: timings,
+	}
+}
+
+// NewDefaultServer builds a server backed by the IDFM referential and PRIM
+func NewDefaultServer() *Server {
+	return NewServer(line.Resolver{}, stop.Resolver{}, time.Provider{})
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"idfm/pkg/data"
+	"idfm/pkg/internal/direction"
+	"idfm/pkg/internal/line"
+	"idfm/pkg/internal/siri"
+	"idfm/pkg/internal/stop"
+	"idfm/pkg/internal/time"
+	"idfm/pkg/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	stdtime "time"
+)
+
+// fakeLines resolves the lines it knows by name, and the termini of their directions
+type fakeLines struct {
+	// ids are the IDFM line IDs by line name
+	ids map[string]string
+	// ambiguous are the candidates returned for a line name matching several lines
+	ambiguous map[string][]string
+	// termini are the direction codes by terminus name
+	termini map[string]string
+}
+
+func (f *fakeLines) GetLineDetailsOrCache(lineType string, submode string, lineId string, operator string) (string, error) {
+	if candidates, found := f.ambiguous[lineId]; found {
+		return "", &utils.CandidatesError{Message: fmt.Sprintf("Line \"%s\" is ambiguous", lineId), Candidates: candidates}
+	}
+	if id, found := f.ids[lineId]; found {
+		return id, nil
+	}
+	return "", &utils.RequestError{Message: fmt.Sprintf("Line \"%s\" not found", lineId)}
+}
+
+func (f *fakeLines) GetLine(lineType string, submode string, lineId string, operator string) (line.Details, error) {
+	id, err := f.GetLineDetailsOrCache(lineType, submode, lineId, operator)
+	return line.Details{ID: id, Name: lineId, Type: lineType}, err
+}
+
+func (f *fakeLines) ListLines(lineType string, submode string, operator string) ([]line.Details, error) {
+	return nil, nil
+}
+
+func (f *fakeLines) GetLineDetailsByIds(lineIds []string) (map[string]line.Details, error) {
+	details := map[string]line.Details{}
+	for name, id := range f.ids {
+		if slices.Contains(lineIds, id) {
+			details[id] = line.Details{ID: id, Name: name}
+		}
+	}
+	return details, nil
+}
+
+func (f *fakeLines) ListOperators(lineType string, submode string) ([]line.Operator, error) {
+	return nil, nil
+}
+
+func (f *fakeLines) GetDirections(lineType string, submode string, lineId string, operator string) ([]direction.Direction, error) {
+	return nil, nil
+}
+
+func (f *fakeLines) MatchDirection(lineId string, value string) (string, error) {
+	if value == "" || slices.Contains(direction.Codes, value) {
+		return value, nil
+	}
+	if code, found := f.termini[value]; found {
+		return code, nil
+	}
+	return "", &utils.RequestError{Message: fmt.Sprintf("Invalid direction: %s", value)}
+}
+
+// fakeStops resolves the stops it knows by name, and keeps the stop IDs cached by the handlers
+type fakeStops struct {
+	// stopIDs are the stop IDs by stop name
+	stopIDs map[string][]utils.StopId
+	cached  map[data.StopCacheKey]utils.StopId
+}
+
+func (f *fakeStops) GetCachedStopIDsForDirection(lineId string, stopName string, direction string, platform string) (utils.StopId, bool) {
+	stopID, found := f.cached[data.StopCacheKey{LineId: lineId, StopName: stopName, Direction: direction, Platform: platform}]
+	return stopID, found
+}
+
+func (f *fakeStops) CacheStopIDForDirection(lineId string, stopName string, direction string, platform string, stopID utils.StopId) {
+	if f.cached == nil {
+		f.cached = map[data.StopCacheKey]utils.StopId{}
+	}
+	f.cached[data.StopCacheKey{LineId: lineId, StopName: stopName, Direction: direction, Platform: platform}] = stopID
+}
+
+func (f *fakeStops) GetStopIDs(lineId string, stopName string) ([]utils.StopId, error) {
+	return f.GetStopIDsByName(stopName, "")
+}
+
+func (f *fakeStops) GetStopIDsByName(stopName string, town string) ([]utils.StopId, error) {
+	if stopIDs, found := f.stopIDs[stopName]; found {
+		return stopIDs, nil
+	}
+	return nil, &utils.RequestError{Message: fmt.Sprintf("Stop \"%s\" not found", stopName)}
+}
+
+func (f *fakeStops) GetStopIDsOnLines(stopName string, lineIds []string) ([]utils.StopId, error) {
+	return f.GetStopIDsByName(stopName, "")
+}
+
+func (f *fakeStops) Search(query stop.SearchQuery) ([]stop.SearchResult, error) {
+	return nil, nil
+}
+
+func (f *fakeStops) Nearby(query stop.NearbyQuery) ([]stop.SearchResult, error) {
+	return nil, nil
+}
+
+// fakeTimings serves the visits it holds by stop ID, and records the stop IDs requested
+type fakeTimings struct {
+	visits map[string][]siri.MonitoredStopVisit
+	// failed are the stop IDs whose timings fail
+	failed    map[string]bool
+	requested []utils.StopId
+}
+
+func (f *fakeTimings) GetAllTimings(ctx context.Context, stopIDs []utils.StopId) (time.Timings, error) {
+	f.requested = append(f.requested, stopIDs...)
+
+	var timings time.Timings
+	for _, stopID := range stopIDs {
+		if f.failed[stopID.Id] {
+			timings.Failed = append(timings.Failed, time.FailedRef{MonitoringRef: "STIF:StopPoint:Q:" + stopID.Id + ":", Reason: "error"})
+			continue
+		}
+		timings.Visits = append(timings.Visits, f.visits[stopID.Id]...)
+	}
+	return timings, nil
+}
+
+// visit builds a visit of the line at the stop, leaving in the given number of minutes in the direction of the code
+func visit(lineId string, stopId string, code string, destination string, minutes int) siri.MonitoredStopVisit {
+	expected := stdtime.Now().Add(stdtime.Duration(minutes)*stdtime.Minute + 30*stdtime.Second)
+	return siri.MonitoredStopVisit{
+		MonitoringRef: siri.ValueWrapper{Value: "STIF:StopPoint:Q:" + stopId + ":"},
+		MonitoredVehicleJourney: siri.MonitoredVehicleJourney{
+			LineRef:         siri.ValueWrapper{Value: "STIF:Line::" + lineId + ":"},
+			DirectionRef:    siri.ValueWrapper{Value: "STIF:Direction:" + lineId + ":" + code},
+			DestinationName: []siri.ValueWrapper{{Value: destination}},
+			MonitoredCall: siri.MonitoredCall{
+				AimedDepartureTime:    expected,
+				ExpectedDepartureTime: expected,
+			},
+		},
+	}
+}
+
+// serve runs a request against the routes of the server, decoding the JSON response into body when given
+func serve(server *Server, path string, body any) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/timings/:type/:id/:stop", server.IDFMTimeHandler())
+	router.GET("/timings/stoppoint/:id", server.IDFMStopPointTimeHandler())
+	router.GET("/boards/:stop", server.IDFMBoardHandler())
+	router.GET("/v2/timings/:type/:id/:stop", server.IDFMTimeHandlerV2())
+	router.GET("/v2/boards/:stop", server.IDFMBoardHandlerV2())
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	if body != nil && recorder.Code == http.StatusOK {
+		_ = json.Unmarshal(recorder.Body.Bytes(), body)
+	}
+	return recorder
+}
+
+// rerA is a server knowing RER A and its stop Auber, served by two stop IDs with a visit in each direction
+func rerA() (*Server, *fakeStops, *fakeTimings) {
+	lines := &fakeLines{
+		ids:       map[string]string{"A": "C01742", "B": "C01743"},
+		ambiguous: map[string][]string{"1": {"C01371", "C00001"}},
+		termini:   map[string]string{"Chessy": "A", "Cergy": "R"},
+	}
+	stops := &fakeStops{stopIDs: map[string][]utils.StopId{
+		"Auber": {{Id: "473921", Type: utils.Point}, {Id: "473922", Type: utils.Point}},
+	}}
+	timings := &fakeTimings{visits: map[string][]siri.MonitoredStopVisit{
+		"473921": {visit("C01742", "473921", "A", "Chessy", 5)},
+		"473922": {visit("C01742", "473922", "R", "Cergy", 3)},
+	}}
+	return NewServer(lines, stops, timings), stops, timings
+}
@@ -4,9 +4,18 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"idfm/pkg/internal/apikey"
+	"idfm/pkg/internal/direction"
+	"idfm/pkg/internal/line"
+	"idfm/pkg/internal/quota"
+	"idfm/pkg/internal/siri"
+	"idfm/pkg/internal/time"
+	"idfm/pkg/internal/upstream"
 	"idfm/pkg/internal/utils"
 	"net/http"
 	"slices"
+	"strconv"
+	"strings"
 )
 
 func validateTransportType(transportType string) (string, error) {
@@ -16,12 +25,212 @@ func validateTransportType(transportType string) (string, error) {
 	return "", &utils.RequestError{Message: fmt.Sprintf("Invalid transport type: %s. Valid types: %s", transportType, utils.AllowedTransportTypes)}
 }
 
+// validateOptionalSubmode validates an optional transport submode query parameter
+func validateOptionalSubmode(submode string) (string, error) {
+	if submode == "" || slices.Contains(utils.AllowedTransportSubmodes, submode) {
+		return submode, nil
+	}
+	return "", &utils.RequestError{Message: fmt.Sprintf("Invalid transport submode: %s. Valid submodes: %s", submode, utils.AllowedTransportSubmodes)}
+}
+
+// parseLimit parses an optional limit query parameter, capped to maxLimit
+func parseLimit(value string, defaultLimit int, maxLimit int) (int, error) {
+	if value == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, &utils.RequestError{Message: fmt.Sprintf("Invalid limit: %s", value)}
+	}
+	return min(limit, maxLimit), nil
+}
+
+// parseCoordinate parses a required latitude or longitude query parameter, within [-bound, bound]
+func parseCoordinate(name string, value string, bound float64) (float64, error) {
+	coordinate, err := strconv.ParseFloat(value, 64)
+	if err != nil || coordinate < -bound || coordinate > bound {
+		return 0, &utils.RequestError{Message: fmt.Sprintf("Invalid %s: %s", name, value)}
+	}
+	return coordinate, nil
+}
+
+// parseRadius parses an optional radius query parameter in meters, capped to maxRadius
+func parseRadius(value string, defaultRadius float64, maxRadius float64) (float64, error) {
+	if value == "" {
+		return defaultRadius, nil
+	}
+	radius, err := strconv.ParseFloat(value, 64)
+	if err != nil || radius <= 0 {
+		return 0, &utils.RequestError{Message: fmt.Sprintf("Invalid radius: %s", value)}
+	}
+	return min(radius, maxRadius), nil
+}
+
+// parseSelection parses the optional limit, from, to and dest query parameters, from and to being minutes from now.
+// walk is the number of minutes needed to reach the stop: departures leaving sooner are left out.
+func parseSelection(c *gin.Context) (time.Selection, error) {
+	limit, err := parseLimit(c.Query("limit"), 0, 100)
+	if err != nil {
+		return time.Selection{}, err
+	}
+
+	minutes := map[string]int{}
+	for _, name := range []string{"from", "to", "walk"} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return time.Selection{}, &utils.RequestError{Message: fmt.Sprintf("Invalid %s: %s. Expected a number of minutes", name, value)}
+		}
+		minutes[name] = parsed
+	}
+
+	selection := time.Selection{
+		From:        max(minutes["from"], minutes["walk"]),
+		Limit:       limit,
+		Destination: strings.TrimSpace(c.Query("dest")),
+	}
+	// to=0 keeps the departures due now, unlike a missing to
+	if to, bounded := minutes["to"]; bounded {
+		if to < selection.From {
+			return time.Selection{}, &utils.RequestError{Message: fmt.Sprintf("Invalid time window: from %d to %d minutes", selection.From, to)}
+		}
+		selection.To = &to
+	}
+	return selection, nil
+}
+
+// maxViaStops is the number of stop IDs of the via stop whose timings are requested
+const maxViaStops = 4
+
+// selectVia restricts the selection to the journeys calling at the via stop afterwards, when the via query parameter is set.
+// The via stop is looked up on the line when given, or among the stops of the lines of the visits otherwise.
+func (s *Server) selectVia(c *gin.Context, lineID string, visits []siri.MonitoredStopVisit, selection *time.Selection) ([]time.FailedRef, error) {
+	via := c.Query("via")
+	if via == "" {
+		return nil, nil
+	}
+
+	var stopIDs []utils.StopId
+	var err error
+	if lineID != "" {
+		stopIDs, err = s.stops.GetStopIDs(lineID, via)
+	} else {
+		stopIDs, err = s.stops.GetStopIDsOnLines(via, time.LineIds(visits))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Every via stop costs an upstream call, only made to tell which journeys call there
+	var skipped []time.FailedRef
+	if len(stopIDs) > maxViaStops {
+		skipped = time.Skipped(stopIDs[maxViaStops:])
+		stopIDs = stopIDs[:maxViaStops]
+	}
+
+	viaTimings, err := s.timings.GetAllTimings(c.Request.Context(), stopIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	selection.Via = time.JourneyTimes(viaTimings.Visits)
+	return append(viaTimings.Failed, skipped...), nil
+}
+
+// resolveDirection returns the direction code designated by a direction query parameter:
+// "A" or "R", or the name of a terminus of the line when a line is given
+func (s *Server) resolveDirection(lineID string, value string) (string, error) {
+	if lineID == "" {
+		if value != "" && !slices.Contains(direction.Codes, value) {
+			return "", &utils.RequestError{Message: fmt.Sprintf("Invalid direction: %s. A terminus can only be given along with a line", value)}
+		}
+		return value, nil
+	}
+	return s.lines.MatchDirection(lineID, value)
+}
+
+// resolveLineFilter returns the line ID designated by a line query parameter:
+// a line name when the transport type is given, or an IDFM line ID such as "C01742" otherwise
+func (s *Server) resolveLineFilter(transportType string, submode string, lineParam string, operator string) (string, error) {
+	if lineParam == "" {
+		return "", nil
+	}
+	if transportType == "" {
+		if !line.IsLineId(lineParam) {
+			return "", &utils.RequestError{Message: fmt.Sprintf("Invalid line: %s. A line is given by IDFM line ID, such as C01742, or by name along with type", lineParam)}
+		}
+		return strings.TrimPrefix(lineParam, "IDFM:"), nil
+	}
+	return s.lines.GetLineDetailsOrCache(transportType, submode, lineParam, operator)
+}
+
+// filterDepartures keeps the departures of the lines satisfying keep
+func filterDepartures[T any](departures []time.LineGroup[T], keep func(details line.Details) bool) []time.LineGroup[T] {
+	filtered := make([]time.LineGroup[T], 0, len(departures))
+	for _, lineDepartures := range departures {
+		if keep(lineDepartures.Line) {
+			filtered = append(filtered, lineDepartures)
+		}
+	}
+	return filtered
+}
+
+// setFailedRefsHeader lists the monitoring refs missing from a partial response, as "<ref>=<reason>" pairs
+func setFailedRefsHeader(c *gin.Context, failed []time.FailedRef) {
+	if len(failed) == 0 {
+		return
+	}
+
+	refs := make([]string, len(failed))
+	for index, failedRef := range failed {
+		refs[index] = failedRef.MonitoringRef + "=" + failedRef.Reason
+	}
+	c.Header("X-Failed-Monitoring-Refs", strings.Join(refs, ", "))
+}
+
+// validateOptionalTransportType validates a transport type filter, empty meaning all types
+func validateOptionalTransportType(transportType string) (string, error) {
+	if transportType == "" {
+		return "", nil
+	}
+	return validateTransportType(transportType)
+}
+
 func handleGinError(c *gin.Context, err error) {
+	var candidatesError *utils.CandidatesError
+	if errors.As(err, &candidatesError) {
+		c.JSON(http.StatusBadRequest, gin.H{"request error": err.Error(), "candidates": candidatesError.Candidates})
+		return
+	}
 	var requestError *utils.RequestError
 	if errors.As(err, &requestError) {
 		c.JSON(http.StatusBadRequest, gin.H{"request error": err.Error()})
 		return
 	}
+	var exhaustedError *quota.ExhaustedError
+	if errors.As(err, &exhaustedError) {
+		c.Header("Retry-After", strconv.Itoa(exhaustedError.RetryAfter()))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": exhaustedError.Error(), "reset": exhaustedError.ResetAt})
+		return
+	}
+	var rejectedError *apikey.RejectedError
+	if errors.As(err, &rejectedError) {
+		c.JSON(rejectedError.StatusCode, gin.H{"error": rejectedError.Error()})
+		return
+	}
+	var unavailableError *apikey.UnavailableError
+	if errors.As(err, &unavailableError) {
+		c.Header("Retry-After", strconv.Itoa(unavailableError.RetryAfter()))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": unavailableError.Error()})
+		return
+	}
+	if errors.Is(err, upstream.ErrCircuitOpen) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	return
 }
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"idfm/pkg/internal/siri"
+	"idfm/pkg/internal/time"
+)
+
+// departuresResponse is the response of the v2 timings endpoints
+type departuresResponse struct {
+	Departures []time.Departure `json:"departures"`
+	// Failures are the monitoring refs whose departures are missing from a partial response
+	Failures []time.FailedRef `json:"failures"`
+}
+
+// boardResponse is the response of the v2 board endpoint
+type boardResponse struct {
+	Lines    []time.LineGroup[time.Departure] `json:"lines"`
+	Failures []time.FailedRef                 `json:"failures"`
+}
+
+func newDeparturesResponse(visits []siri.MonitoredStopVisit, failed []time.FailedRef) departuresResponse {
+	return departuresResponse{
+		Departures: time.ToDepartures(visits),
+		Failures:   failures(failed),
+	}
+}
+
+// failures returns the failed monitoring refs, as an empty list rather than null when there is none
+func failures(failed []time.FailedRef) []time.FailedRef {
+	if failed == nil {
+		return []time.FailedRef{}
+	}
+	return failed
+}
@@ -0,0 +1,192 @@
+package apikey
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"idfm/pkg/env"
+	"idfm/pkg/internal/quota"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// UnavailableError is returned when every API key is benched or out of quota
+type UnavailableError struct {
+	RetryAt time.Time
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("no PRIM API key available until %s", e.RetryAt.Format(time.RFC3339))
+}
+
+// RetryAfter returns the number of seconds until a key becomes available again
+func (e *UnavailableError) RetryAfter() int {
+	return int(max(1, time.Until(e.RetryAt).Seconds()+1))
+}
+
+// Health describes the state of an API key, identified by its fingerprint
+type Health struct {
+	Key          string     `json:"key"`
+	Healthy      bool       `json:"healthy"`
+	BenchedUntil *time.Time `json:"benchedUntil,omitempty"`
+	LastStatus   int        `json:"lastStatus,omitempty"`
+	Used         int        `json:"used"`
+}
+
+type key struct {
+	value        string
+	benchedUntil time.Time
+	lastStatus   int
+}
+
+var (
+	mu   sync.Mutex
+	keys []*key
+	next int
+)
+
+func init() {
+	for _, value := range env.IDFM_API_KEYS {
+		k := &key{value: value}
+		keys = append(keys, k)
+		quota.Pin(value)
+		registerHealthyMetric(k)
+	}
+}
+
+func registerHealthyMetric(k *key) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "idfm",
+		Name:      "api_key_healthy",
+		Help:      "Whether the API key is currently used for stop monitoring calls",
+		ConstLabels: prometheus.Labels{
+			"key": quota.Fingerprint(k.value),
+		},
+	}, func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if k.isBenched(time.Now()) {
+			return 0
+		}
+		return 1
+	})
+}
+
+func (k *key) isBenched(now time.Time) bool {
+	return now.Before(k.benchedUntil)
+}
+
+// Pick returns the API key to use for the next stop monitoring call, skipping the excluded ones.
+// Keys are picked round-robin, or by least used quota with the "quota" strategy.
+func Pick(excluded map[string]bool) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	var candidates []*key
+	for offset := range keys {
+		k := keys[(next+offset)%len(keys)]
+		if !excluded[k.value] && !k.isBenched(now) && quota.Check(k.value) != quota.Hard {
+			candidates = append(candidates, k)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", &UnavailableError{RetryAt: retryAt(now)}
+	}
+
+	picked := candidates[0]
+	if env.IDFM_API_KEY_STRATEGY == "quota" {
+		for _, candidate := range candidates[1:] {
+			if quota.Used(candidate.value) < quota.Used(picked.value) {
+				picked = candidate
+			}
+		}
+	}
+
+	for index, k := range keys {
+		if k == picked {
+			next = index + 1
+		}
+	}
+	return picked.value, nil
+}
+
+// Level returns the best quota level among the keys that are not benched.
+// When every key is benched, stale data should be preferred, so Soft is returned.
+func Level() quota.Level {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	level := quota.Soft
+	anyAvailable := false
+	for _, k := range keys {
+		if k.isBenched(now) {
+			continue
+		}
+		if keyLevel := quota.Check(k.value); !anyAvailable || keyLevel < level {
+			level = keyLevel
+		}
+		anyAvailable = true
+	}
+	return level
+}
+
+// IsRejection tells whether PRIM rejected the key itself, in which case another key should be tried
+func IsRejection(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden || statusCode == http.StatusTooManyRequests
+}
+
+// Bench leaves the key aside after PRIM rejected it, for the requested delay or the configured duration
+func Bench(apiKey string, statusCode int, retryAfter time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	duration := env.IDFM_API_KEY_BENCH
+	if retryAfter > 0 {
+		duration = retryAfter
+	}
+
+	for _, k := range keys {
+		if k.value == apiKey {
+			k.benchedUntil = time.Now().Add(duration)
+			k.lastStatus = statusCode
+		}
+	}
+}
+
+// Healths returns the state of every API key
+func Healths() []Health {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	healths := make([]Health, len(keys))
+	for index, k := range keys {
+		healths[index] = Health{
+			Key:        quota.Fingerprint(k.value),
+			Healthy:    !k.isBenched(now),
+			LastStatus: k.lastStatus,
+			Used:       quota.Used(k.value),
+		}
+		if k.isBenched(now) {
+			benchedUntil := k.benchedUntil
+			healths[index].BenchedUntil = &benchedUntil
+		}
+	}
+	return healths
+}
+
+// retryAt returns when a key becomes available again: the end of the shortest bench, or the quota reset. The caller must hold mu.
+func retryAt(now time.Time) time.Time {
+	earliest := quota.ResetAt()
+	for _, k := range keys {
+		if k.isBenched(now) && k.benchedUntil.Before(earliest) && quota.Check(k.value) != quota.Hard {
+			earliest = k.benchedUntil
+		}
+	}
+	return earliest
+}
@@ -0,0 +1,37 @@
+package apikey
+
+import (
+	"context"
+	"fmt"
+)
+
+// ClientKeyHeader is the request header through which clients may provide their own PRIM API key
+const ClientKeyHeader = "X-Prim-Api-Key"
+
+type clientKeyContextKey struct{}
+
+// RejectedError is returned when PRIM rejects the API key provided by the client.
+// It never holds the key itself.
+type RejectedError struct {
+	StatusCode int
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("PRIM rejected the provided API key (status %d)", e.StatusCode)
+}
+
+// WithClientKey returns a context carrying the API key provided by the client
+func WithClientKey(ctx context.Context, clientKey string) context.Context {
+	return context.WithValue(ctx, clientKeyContextKey{}, clientKey)
+}
+
+// ClientKey returns the API key provided by the client, if any
+func ClientKey(ctx context.Context) (string, bool) {
+	clientKey, ok := ctx.Value(clientKeyContextKey{}).(string)
+	return clientKey, ok && clientKey != ""
+}
+
+// HasServerKeys tells whether the server has API keys of its own
+func HasServerKeys() bool {
+	return len(keys) > 0
+}
@@ -0,0 +1,281 @@
+// Package direction tells the direction of a visit, "A" or "R", from the termini of its line.
+package direction
+
+import (
+	"encoding/json"
+	"fmt"
+	"idfm/pkg/env"
+	"idfm/pkg/internal/fuzzy"
+	"idfm/pkg/internal/referential"
+	"idfm/pkg/internal/siri"
+	"idfm/pkg/internal/utils"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Codes are the direction codes, in the order directions are listed
+var Codes = []string{"A", "R"}
+
+// gtfsCodes are the direction codes of the GTFS direction IDs: the outbound direction ("0") is the Aller one, "A"
+var gtfsCodes = map[string]string{"0": "A", "1": "R"}
+
+// Rule overrides the direction resolution of a line
+type Rule struct {
+	// Directions are the termini of each direction code, replacing those of the trips file.
+	// A terminus is a stop name, or a stop ref such as "STIF:StopPoint:Q:411343:" matched against the destination ref.
+	Directions map[string][]string `json:"directions"`
+	// GTFS are the direction codes of the GTFS direction IDs of the line in the trips file, when they differ from
+	// "0" for "A" and "1" for "R"
+	GTFS map[string]string `json:"gtfs"`
+	// Parity tells whether the parity of mission numbers tells the direction when termini do not, true by default
+	Parity *bool `json:"parity"`
+}
+
+// Direction is a direction of a line along with its termini
+type Direction struct {
+	// Code is "A" or "R", omitted for the termini of a GTFS direction that has no code
+	Code    string   `json:"code,omitempty"`
+	Termini []string `json:"termini"`
+}
+
+// rules are the rules of the directions file by line ID, empty when no file is configured
+var rules atomic.Pointer[map[string]Rule]
+
+// Load reads the configured directions file
+func Load() error {
+	lineRules := map[string]Rule{}
+
+	if env.IDFM_DIRECTIONS_FILE != "" {
+		content, err := os.ReadFile(env.IDFM_DIRECTIONS_FILE)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(content, &lineRules); err != nil {
+			return fmt.Errorf("invalid directions file %s: %w", env.IDFM_DIRECTIONS_FILE, err)
+		}
+		for lineId, rule := range lineRules {
+			for code := range rule.Directions {
+				if !slices.Contains(Codes, code) {
+					return fmt.Errorf("invalid directions file %s: unknown direction %s of line %s", env.IDFM_DIRECTIONS_FILE, code, lineId)
+				}
+			}
+			for directionId, code := range rule.GTFS {
+				if !slices.Contains(Codes, code) {
+					return fmt.Errorf("invalid directions file %s: unknown direction %s for GTFS direction %s of line %s", env.IDFM_DIRECTIONS_FILE, code, directionId, lineId)
+				}
+			}
+		}
+		log.Printf("Direction rules loaded for %d lines", len(lineRules))
+	}
+
+	rules.Store(&lineRules)
+	return nil
+}
+
+// ruleOf returns the rule of the line, the zero rule when there is none
+func ruleOf(lineId string) Rule {
+	if lineRules := rules.Load(); lineRules != nil {
+		return (*lineRules)[lineId]
+	}
+	return Rule{}
+}
+
+// Directions returns the directions of the line with their termini. The termini come from its rule, or else from the
+// trips file, each GTFS direction taking the code given by the rule or else by its direction ID.
+func Directions(lineId string) []Direction {
+	termini := map[string][]string{}
+	var unmapped []Direction
+
+	if rule := ruleOf(lineId); len(rule.Directions) > 0 {
+		termini = rule.Directions
+	} else {
+		for _, gtfsDirection := range gtfsDirections(lineId) {
+			if code := gtfsCode(rule, gtfsDirection.Code); code != "" {
+				termini[code] = append(termini[code], gtfsDirection.Termini...)
+			} else {
+				unmapped = append(unmapped, Direction{Termini: gtfsDirection.Termini})
+			}
+		}
+	}
+
+	directions := make([]Direction, 0, len(Codes)+len(unmapped))
+	for _, code := range Codes {
+		direction := Direction{Code: code, Termini: termini[code]}
+		if direction.Termini == nil {
+			direction.Termini = []string{}
+		}
+		directions = append(directions, direction)
+	}
+	return append(directions, unmapped...)
+}
+
+// gtfsCode returns the direction code of a GTFS direction ID of the line, or "" when it has none
+func gtfsCode(rule Rule, directionId string) string {
+	if code, found := rule.GTFS[directionId]; found {
+		return code
+	}
+	return gtfsCodes[directionId]
+}
+
+// gtfsDirections returns the termini of the line in the trips file, by GTFS direction ID rather than by code
+func gtfsDirections(lineId string) []Direction {
+	index := referential.Current()
+	if index == nil {
+		return nil
+	}
+
+	termini := index.Termini(lineId)
+	directionIds := slices.Sorted(maps.Keys(termini))
+	directions := make([]Direction, len(directionIds))
+	for position, directionId := range directionIds {
+		directions[position] = Direction{Code: directionId, Termini: termini[directionId]}
+	}
+	return directions
+}
+
+// Match returns the direction code designated by a direction parameter: a code, or the name of a terminus of the line
+func Match(lineId string, value string) (string, error) {
+	if value == "" || slices.Contains(Codes, value) {
+		return value, nil
+	}
+
+	directions := Directions(lineId)
+	if code := terminusDirection(directions, "", value); code != "" {
+		return code, nil
+	}
+
+	return "", &utils.CandidatesError{
+		Message:    fmt.Sprintf("Invalid direction \"%s\". Expected A, R or a terminus", value),
+		Candidates: directions,
+	}
+}
+
+// Resolve returns the direction of a visit, "A" or "R", or "" when it cannot be told
+func Resolve(entry siri.MonitoredStopVisit) string {
+	journey := entry.MonitoredVehicleJourney
+	lineId := strings.TrimSuffix(strings.TrimPrefix(journey.LineRef.Value, "STIF:Line::"), ":")
+	destinationName := ""
+	if len(journey.DestinationName) > 0 {
+		destinationName = journey.DestinationName[0].Value
+	}
+
+	// Unambiguous explicit suffixes take highest priority
+	if code := suffixed(journey.DirectionRef.Value); code != "" {
+		return code
+	}
+
+	// Then the termini of the line, from its rule or else from the trips file, when the destination is one of them
+	if code := terminusDirection(Directions(lineId), journey.DestinationRef.Value, destinationName); code != "" {
+		return code
+	}
+
+	// As a last resort, the mission number or the direction text of the visit
+	return told(journey, ruleOf(lineId))
+}
+
+// suffixed returns the direction given by the ":A" or ":R" suffix of a direction ref, or ""
+func suffixed(dirRefValue string) string {
+	if strings.HasSuffix(dirRefValue, ":A") {
+		return "A"
+	} else if strings.HasSuffix(dirRefValue, ":R") {
+		return "R"
+	}
+	return ""
+}
+
+// told returns the direction told by the mission number or the direction text of the visit, or "" when they tell none
+func told(journey siri.MonitoredVehicleJourney, rule Rule) string {
+	dirRefValue := journey.DirectionRef.Value
+
+	// For rail services (RER/Transilien), DirectionRef is always "Aller" for every
+	// train regardless of travel direction. Use mission number parity instead:
+	//   even last digit → ascending / eastbound / toward Paris  (A)
+	//   odd  last digit → descending / westbound / away from Paris (R)
+	// This does not hold on every branch, so it can be disabled per line.
+	if rule.Parity == nil || *rule.Parity {
+		if names := journey.VehicleJourneyName; len(names) > 0 {
+			if name := names[0].Value; len(name) > 0 {
+				if last := name[len(name)-1]; last >= '0' && last <= '9' {
+					if (last-'0')%2 == 0 {
+						return "A"
+					}
+					return "R"
+				}
+			}
+		}
+	}
+
+	// Fall back to text-based direction (buses, tram)
+	switch dirRefValue {
+	case "Aller":
+		return "A"
+	case "Retour":
+		return "R"
+	}
+	if len(journey.DirectionName) > 0 {
+		switch journey.DirectionName[0].Value {
+		case "Aller":
+			return "A"
+		case "Retour":
+			return "R"
+		}
+	}
+	return ""
+}
+
+// terminusDirection returns the code of the only direction one of whose termini is the destination, given by ref or name,
+// or "" when there is none or several. Directions without code are ignored.
+func terminusDirection(directions []Direction, destinationRef string, destinationName string) string {
+	bestCode, bestScore, tied := "", 0.0, false
+
+	for _, direction := range directions {
+		if direction.Code == "" {
+			continue
+		}
+		for _, terminus := range direction.Termini {
+			var score float64
+			if isStopRef(terminus) {
+				if destinationRef != "" && sameStop(terminus, destinationRef) {
+					score = 1
+				}
+			} else if destinationName != "" {
+				score = fuzzy.Score(destinationName, terminus)
+			}
+
+			if score < fuzzy.MinScore || score < bestScore {
+				continue
+			}
+			if score == bestScore {
+				// Another terminus of the same direction leaves a tie between different directions as it is
+				if bestCode != direction.Code {
+					tied = true
+				}
+				continue
+			}
+			bestCode, bestScore, tied = direction.Code, score, false
+		}
+	}
+
+	if tied {
+		return ""
+	}
+	return bestCode
+}
+
+// isStopRef tells whether a terminus of the directions file is a stop ref rather than a name
+func isStopRef(terminus string) bool {
+	return strings.HasPrefix(terminus, "STIF:") || (terminus != "" && utils.OnlyNumberRegex.FindString(terminus) == terminus)
+}
+
+// sameStop compares stop refs through their numeric IDs
+func sameStop(a string, b string) bool {
+	if a == b {
+		return true
+	}
+	id := utils.OnlyNumberRegex.FindString(a)
+	return id != "" && id == utils.OnlyNumberRegex.FindString(b)
+}
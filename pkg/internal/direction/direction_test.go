@@ -0,0 +1,157 @@
+package direction
+
+import (
+	"idfm/pkg/env"
+	"idfm/pkg/internal/referential"
+	"idfm/pkg/internal/siri"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// load loads a trips file and a directions file:
+// RER A has its GTFS directions in the default order, RER B in the reverse order,
+// and metro 1 has its termini and parity set by its rule
+func load(t *testing.T) {
+	dir := t.TempDir()
+	trips := "trip_id,direction_id,route_id,service_id,trip_headsign\n" +
+		"1,0,IDFM:C01742,S,Marne-la-Vallée Chessy\n" +
+		"2,0,IDFM:C01742,S,Boissy-Saint-Léger\n" +
+		"3,1,IDFM:C01742,S,Saint-Germain-en-Laye\n" +
+		"4,0,IDFM:C01743,S,Robinson\n" +
+		"5,1,IDFM:C01743,S,Aéroport Charles de Gaulle 2\n" +
+		"6,2,IDFM:C01743,S,Massy-Palaiseau\n" +
+		"7,0,IDFM:C01371,S,La Défense\n"
+	directions := `{
+		"C01743": {"gtfs": {"0": "R", "1": "A"}},
+		"C01371": {"directions": {"A": ["Château de Vincennes"], "R": ["STIF:StopPoint:Q:411343:"]}, "parity": false}
+	}`
+	tripsPath, directionsPath := filepath.Join(dir, "trips.txt"), filepath.Join(dir, "directions.json")
+	if err := os.WriteFile(tripsPath, []byte(trips), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(directionsPath, []byte(directions), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	env.IDFM_TRIPS_DATASET_FILE, env.IDFM_DIRECTIONS_FILE = tripsPath, directionsPath
+	t.Cleanup(func() {
+		env.IDFM_TRIPS_DATASET_FILE, env.IDFM_DIRECTIONS_FILE = "", ""
+		_ = referential.Load()
+		_ = Load()
+	})
+	if err := referential.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirections(t *testing.T) {
+	load(t)
+
+	tests := []struct {
+		name   string
+		lineId string
+		want   []Direction
+	}{
+		{"default GTFS codes", "C01742", []Direction{
+			{Code: "A", Termini: []string{"Boissy-Saint-Léger", "Marne-la-Vallée Chessy"}},
+			{Code: "R", Termini: []string{"Saint-Germain-en-Laye"}},
+		}},
+		{"GTFS codes of the rule", "C01743", []Direction{
+			{Code: "A", Termini: []string{"Aéroport Charles de Gaulle 2"}},
+			{Code: "R", Termini: []string{"Robinson"}},
+			{Termini: []string{"Massy-Palaiseau"}},
+		}},
+		{"termini of the rule", "C01371", []Direction{
+			{Code: "A", Termini: []string{"Château de Vincennes"}},
+			{Code: "R", Termini: []string{"STIF:StopPoint:Q:411343:"}},
+		}},
+		{"unknown line", "C09999", []Direction{
+			{Code: "A", Termini: []string{}},
+			{Code: "R", Termini: []string{}},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if directions := Directions(test.lineId); !reflect.DeepEqual(directions, test.want) {
+				t.Errorf("Directions(%s) = %+v, want %+v", test.lineId, directions, test.want)
+			}
+		})
+	}
+}
+
+func TestTerminusDirection(t *testing.T) {
+	directions := []Direction{
+		{Code: "A", Termini: []string{"Marne-la-Vallée Chessy", "Gare de Lyon"}},
+		{Code: "R", Termini: []string{"Saint-Germain-en-Laye", "STIF:StopPoint:Q:411343:", "Gare de Lyon"}},
+		{Code: "A", Termini: []string{"Gare de Lyon"}},
+		{Termini: []string{"Massy-Palaiseau"}},
+	}
+
+	tests := []struct {
+		name            string
+		destinationRef  string
+		destinationName string
+		want            string
+	}{
+		{"name", "", "Marne-la-Vallée Chessy", "A"},
+		{"loose name", "", "marne la vallee chessy", "A"},
+		{"ref", "STIF:StopArea:SP:411343:", "Nanterre", "R"},
+		{"tie kept by a terminus of the same direction", "", "Gare de Lyon", ""},
+		{"direction without code", "", "Massy-Palaiseau", ""},
+		{"unknown", "", "Nation", ""},
+		{"nothing", "", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := terminusDirection(directions, test.destinationRef, test.destinationName); code != test.want {
+				t.Errorf("terminusDirection(%q, %q) = %q, want %q", test.destinationRef, test.destinationName, code, test.want)
+			}
+		})
+	}
+}
+
+// visitTo builds a visit of the line with the given direction ref and mission, heading to the destination
+func visitTo(lineId string, directionRef string, mission string, destinationRef string, destinationName string) siri.MonitoredStopVisit {
+	return siri.MonitoredStopVisit{
+		MonitoredVehicleJourney: siri.MonitoredVehicleJourney{
+			LineRef:            siri.ValueWrapper{Value: "STIF:Line::" + lineId + ":"},
+			DirectionRef:       siri.ValueWrapper{Value: directionRef},
+			VehicleJourneyName: []siri.ValueWrapper{{Value: mission}},
+			DestinationRef:     siri.ValueWrapper{Value: destinationRef},
+			DestinationName:    []siri.ValueWrapper{{Value: destinationName}},
+		},
+	}
+}
+
+func TestResolve(t *testing.T) {
+	load(t)
+
+	tests := []struct {
+		name  string
+		entry siri.MonitoredStopVisit
+		want  string
+	}{
+		{"suffix", visitTo("C01742", "STIF:Direction:C01742:R", "QIKI12", "", "Marne-la-Vallée Chessy"), "R"},
+		{"GTFS terminus over parity", visitTo("C01742", "Aller", "QIKI13", "", "Marne-la-Vallée Chessy"), "A"},
+		{"GTFS terminus of the rule code", visitTo("C01743", "Aller", "PAPA12", "", "Robinson"), "R"},
+		{"rule terminus", visitTo("C01371", "Aller", "", "STIF:StopPoint:Q:411343:", "La Défense"), "R"},
+		{"parity of an unknown terminus", visitTo("C01742", "Aller", "UPAC13", "", "Nanterre-Préfecture"), "R"},
+		{"text without parity", visitTo("C01371", "Retour", "7", "", "Bastille"), "R"},
+		{"nothing", visitTo("C09999", "", "", "", "Nation"), ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := Resolve(test.entry); code != test.want {
+				t.Errorf("Resolve() = %q, want %q", code, test.want)
+			}
+		})
+	}
+}
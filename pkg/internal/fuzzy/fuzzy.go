@@ -0,0 +1,189 @@
+// Package fuzzy matches place names the way riders type them: regardless of accents, case, punctuation and abbreviations.
+package fuzzy
+
+import (
+	"golang.org/x/text/unicode/norm"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// MinScore is the score below which a candidate is not considered a match
+const MinScore = 0.5
+
+// ResolveScore is the score a lone candidate needs to be picked without asking, such as a name contained in the
+// candidate or a single typo in a long name
+const ResolveScore = 0.75
+
+// abbreviations maps common abbreviations of stop names to their full form
+var abbreviations = map[string]string{
+	"st":   "saint",
+	"ste":  "sainte",
+	"sts":  "saints",
+	"pte":  "porte",
+	"pl":   "place",
+	"av":   "avenue",
+	"ave":  "avenue",
+	"bd":   "boulevard",
+	"bld":  "boulevard",
+	"fg":   "faubourg",
+	"sq":   "square",
+	"ctre": "centre",
+	"gal":  "general",
+	"gl":   "general",
+	"mal":  "marechal",
+	"pdt":  "president",
+	"pres": "president",
+	"hop":  "hopital",
+	"univ": "universite",
+}
+
+// ligatures are not decomposed by Unicode normalization
+var ligatures = strings.NewReplacer("œ", "oe", "Œ", "oe", "æ", "ae", "Æ", "ae")
+
+// Match is a candidate name with its similarity to the query, between 0 and 1
+type Match struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// Normalize folds a name for comparison: it strips accents, lowercases, turns punctuation into spaces
+// and expands common abbreviations, so that "Gare St-Lazare" and "gare saint lazare" are equal
+func Normalize(name string) string {
+	var builder strings.Builder
+	for _, r := range norm.NFD.String(ligatures.Replace(name)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Combining accent, dropped
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			builder.WriteRune(unicode.ToLower(r))
+		default:
+			builder.WriteRune(' ')
+		}
+	}
+
+	tokens := strings.Fields(builder.String())
+	for index, token := range tokens {
+		if expanded, ok := abbreviations[token]; ok {
+			tokens[index] = expanded
+		}
+	}
+	return strings.Join(tokens, " ")
+}
+
+// Score returns the similarity of a candidate name to the query, between 0 and 1, rounded to two decimals
+func Score(query string, candidate string) float64 {
+	return round(score(Normalize(query), Normalize(candidate)))
+}
+
+// Rank scores the candidates against the query, best first, keeping only the matches
+func Rank(query string, candidates []string) []Match {
+	normalizedQuery := Normalize(query)
+
+	var matches []Match
+	for _, candidate := range candidates {
+		if candidateScore := score(normalizedQuery, Normalize(candidate)); candidateScore >= MinScore {
+			matches = append(matches, Match{Name: candidate, Score: round(candidateScore)})
+		}
+	}
+
+	slices.SortStableFunc(matches, func(a, b Match) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+	return matches
+}
+
+// Resolve returns the match the query unambiguously designates: the only exact match, or the only match at all when
+// it scores at least ResolveScore
+func Resolve(matches []Match) (Match, bool) {
+	if len(matches) == 1 && matches[0].Score >= ResolveScore {
+		return matches[0], true
+	}
+	if len(matches) > 1 && matches[0].Score == 1 && matches[1].Score < 1 {
+		return matches[0], true
+	}
+	return Match{}, false
+}
+
+// score compares normalized names
+func score(query string, candidate string) float64 {
+	if query == "" || candidate == "" {
+		return 0
+	}
+	if query == candidate {
+		return 1
+	}
+
+	queryTokens := strings.Fields(query)
+	candidateTokens := strings.Fields(candidate)
+
+	// "chatelet" in "chatelet les halles"
+	if containsAll(candidateTokens, queryTokens, false) {
+		return 0.7 + 0.2*ratio(queryTokens, candidateTokens)
+	}
+	// "gare saint lazare" for "saint lazare"
+	if containsAll(queryTokens, candidateTokens, false) {
+		return 0.6 + 0.2*ratio(candidateTokens, queryTokens)
+	}
+	// "porte mai" for "porte maillot", as typed in an autocomplete field
+	if containsAll(candidateTokens, queryTokens, true) {
+		return 0.6 + 0.2*ratio(queryTokens, candidateTokens)
+	}
+
+	distance := levenshtein([]rune(query), []rune(candidate))
+	similarity := 1 - float64(distance)/float64(max(len([]rune(query)), len([]rune(candidate))))
+	return 0.8 * similarity
+}
+
+// containsAll tells whether each token is in (or, with prefix, starts) one of the tokens of the haystack
+func containsAll(haystack []string, tokens []string, prefix bool) bool {
+	for _, token := range tokens {
+		found := slices.ContainsFunc(haystack, func(candidate string) bool {
+			if prefix {
+				return strings.HasPrefix(candidate, token)
+			}
+			return candidate == token
+		})
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func round(score float64) float64 {
+	return math.Round(score*100) / 100
+}
+
+func ratio(part []string, whole []string) float64 {
+	return float64(len(part)) / float64(max(len(part), len(whole)))
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,34 @@
+package fuzzy
+
+import (
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		candidates []string
+		want       string
+		resolved   bool
+	}{
+		{"accents and case", "chatelet", []string{"Châtelet"}, "Châtelet", true},
+		{"abbreviation", "Gare St-Lazare", []string{"Gare Saint-Lazare"}, "Gare Saint-Lazare", true},
+		{"only exact match", "Nation", []string{"Nation", "Nationale"}, "Nation", true},
+		{"contained", "Saint Lazare", []string{"Gare Saint-Lazare"}, "Gare Saint-Lazare", true},
+		{"prefix", "Porte mai", []string{"Porte Maillot"}, "Porte Maillot", true},
+		{"typo", "Chatelot", []string{"Châtelet"}, "", false},
+		{"weak lone match", "Gare", []string{"Gare de l'Est Verdun Saint-Martin Magenta"}, "", false},
+		{"several matches", "Porte", []string{"Porte Maillot", "Porte d'Orléans"}, "", false},
+		{"no match", "Nation", []string{"Opéra"}, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			match, resolved := Resolve(Rank(test.query, test.candidates))
+			if resolved != test.resolved || match.Name != test.want {
+				t.Errorf("Resolve(%q) = %q, %t, want %q, %t (ranked %v)", test.query, match.Name, resolved, test.want, test.resolved, Rank(test.query, test.candidates))
+			}
+		})
+	}
+}
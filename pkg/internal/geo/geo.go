@@ -0,0 +1,99 @@
+// Package geo indexes items by position to find the ones around a point.
+package geo
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+const (
+	earthRadius = 6371000.0
+	// cellSize is the side of the grid cells, in degrees (about 1.1 km of latitude)
+	cellSize = 0.01
+	// metersPerDegree is the length of a degree of latitude
+	metersPerDegree = earthRadius * math.Pi / 180
+)
+
+// Point is a position in degrees
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+// Distance returns the great-circle distance between two points, in meters
+func Distance(a Point, b Point) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Hit is an item found around a point
+type Hit[T any] struct {
+	Item     T
+	Distance float64
+}
+
+type cell struct {
+	lat int
+	lon int
+}
+
+type entry[T any] struct {
+	item     T
+	position Point
+}
+
+// Grid indexes items in square cells of their position
+type Grid[T any] struct {
+	cells map[cell][]entry[T]
+}
+
+// NewGrid indexes the items at the given positions. Items at (0, 0), i.e. without a known position, are left out.
+func NewGrid[T any](items []T, position func(T) Point) *Grid[T] {
+	grid := &Grid[T]{cells: make(map[cell][]entry[T])}
+	for _, item := range items {
+		point := position(item)
+		if point.Lat == 0 && point.Lon == 0 {
+			continue
+		}
+		c := cellOf(point)
+		grid.cells[c] = append(grid.cells[c], entry[T]{item: item, position: point})
+	}
+	return grid
+}
+
+// Within returns the items at most radius meters away from the center, closest first
+func (g *Grid[T]) Within(center Point, radius float64) []Hit[T] {
+	latDelta := radius / metersPerDegree
+	lonDelta := radius / (metersPerDegree * math.Max(0.01, math.Cos(center.Lat*math.Pi/180)))
+
+	minCell := cellOf(Point{Lat: center.Lat - latDelta, Lon: center.Lon - lonDelta})
+	maxCell := cellOf(Point{Lat: center.Lat + latDelta, Lon: center.Lon + lonDelta})
+
+	var hits []Hit[T]
+	for lat := minCell.lat; lat <= maxCell.lat; lat++ {
+		for lon := minCell.lon; lon <= maxCell.lon; lon++ {
+			for _, e := range g.cells[cell{lat: lat, lon: lon}] {
+				if distance := Distance(center, e.position); distance <= radius {
+					hits = append(hits, Hit[T]{Item: e.item, Distance: distance})
+				}
+			}
+		}
+	}
+
+	slices.SortStableFunc(hits, func(a, b Hit[T]) int {
+		return cmp.Compare(a.Distance, b.Distance)
+	})
+	return hits
+}
+
+func cellOf(point Point) cell {
+	return cell{
+		lat: int(math.Floor(point.Lat / cellSize)),
+		lon: int(math.Floor(point.Lon / cellSize)),
+	}
+}
@@ -0,0 +1,126 @@
+package line
+
+import (
+	"cmp"
+	"github.com/jellydator/ttlcache/v3"
+	"idfm/pkg/data"
+	"idfm/pkg/env"
+	"idfm/pkg/internal/odsql"
+	"idfm/pkg/internal/opendata"
+	"idfm/pkg/internal/referential"
+	"net/url"
+	"slices"
+)
+
+// Details is the display metadata of a line
+type Details struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	ShortName string `json:"shortName,omitempty"`
+	// LongName is only known when the stops dataset is loaded offline
+	LongName         string `json:"longName,omitempty"`
+	Type             string `json:"type"`
+	Submode          string `json:"submode,omitempty"`
+	Operator         string `json:"operator,omitempty"`
+	Network          string `json:"network,omitempty"`
+	BackgroundColour string `json:"backgroundColour,omitempty"`
+	TextColour       string `json:"textColour,omitempty"`
+	Accessibility    string `json:"accessibility,omitempty"`
+	Picto            string `json:"picto,omitempty"`
+}
+
+// GetLine retrieves the details of a line from its type, optional submode and name
+func GetLine(lineType string, submode string, lineId string, operator string) (Details, error) {
+	resLineId, err := GetLineDetailsOrCache(lineType, submode, lineId, operator)
+	if err != nil {
+		return Details{}, err
+	}
+
+	lines, err := GetLinesByIds([]string{resLineId})
+	if err != nil {
+		return Details{}, err
+	}
+
+	if line, ok := lines[resLineId]; ok {
+		return toDetails(line), nil
+	}
+	return Details{ID: resLineId, Name: lineId, Type: lineType}, nil
+}
+
+// ListLines retrieves the details of the lines of that type and submode (all when empty), up to the listing cap
+func ListLines(lineType string, submode string, operator string) ([]Details, error) {
+	if index := referential.Current(); index != nil && len(index.Lines) > 0 {
+		details := []Details{}
+		for _, line := range index.Lines {
+			if line.IsOfMode(lineType, submode) && line.IsRunBy(operators(operator)) {
+				details = append(details, toDetails(line))
+			}
+		}
+		sortDetails(details)
+		return details, nil
+	}
+
+	// Prepare query parameters
+	params := url.Values{}
+	params.Add("where", odsql.And(modeQuery(lineType, submode), operatorQuery(operator)).String())
+	params.Add("order_by", "name_line")
+
+	records, totalCount, err := opendata.GetAllRecords[referential.Line](lineRecordsEndpoint, params, env.IDFM_LISTING_CAP)
+	if err != nil {
+		return nil, err
+	}
+	opendata.Truncated("Listing of the lines", len(records), totalCount)
+
+	details := make([]Details, len(records))
+	for index, record := range records {
+		data.LineByIdCache.Set(record.IDLine, record, ttlcache.DefaultTTL)
+		details[index] = toDetails(record)
+	}
+	sortDetails(details)
+	return details, nil
+}
+
+// GetLineDetailsByIds retrieves the details of the given lines, keyed by line ID. Unknown lines are left out.
+func GetLineDetailsByIds(lineIds []string) (map[string]Details, error) {
+	lines, err := GetLinesByIds(lineIds)
+	if err != nil {
+		return nil, err
+	}
+
+	details := make(map[string]Details, len(lines))
+	for lineId, line := range lines {
+		details[lineId] = toDetails(line)
+	}
+	return details, nil
+}
+
+// toDetails converts a referential line to its display metadata
+func toDetails(line referential.Line) Details {
+	details := Details{
+		ID:               line.IDLine,
+		Name:             line.NameLine,
+		ShortName:        line.ShortNameLine,
+		Type:             line.TransportMode,
+		Submode:          line.TransportSubmode,
+		Operator:         line.OperatorName,
+		Network:          line.NetworkName,
+		BackgroundColour: line.ColourWebHexa,
+		TextColour:       line.TextColourWebHexa,
+		Accessibility:    line.Accessibility,
+		Picto:            string(line.Picto),
+	}
+
+	if index := referential.Current(); index != nil {
+		if stops := index.StopsOfLine(line.IDLine); len(stops) > 0 {
+			details.LongName = stops[0].RouteLongName
+		}
+	}
+	return details
+}
+
+// sortDetails orders lines by type, then by name
+func sortDetails(details []Details) {
+	slices.SortStableFunc(details, func(a, b Details) int {
+		return cmp.Or(cmp.Compare(a.Type, b.Type), cmp.Compare(a.Name, b.Name))
+	})
+}
@@ -0,0 +1,19 @@
+package line
+
+import (
+	"idfm/pkg/internal/direction"
+)
+
+// GetDirections returns the directions of a line, given by name or ID, along with their termini
+func GetDirections(lineType string, submode string, lineId string, operator string) ([]direction.Direction, error) {
+	id, err := GetLineDetailsOrCache(lineType, submode, lineId, operator)
+	if err != nil {
+		return nil, err
+	}
+	return direction.Directions(id), nil
+}
+
+// MatchDirection returns the direction code of a line designated by a code or by the name of one of its termini
+func MatchDirection(lineId string, value string) (string, error) {
+	return direction.Match(lineId, value)
+}
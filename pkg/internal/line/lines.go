@@ -5,120 +5,295 @@ import (
 	"fmt"
 	"github.com/jellydator/ttlcache/v3"
 	"idfm/pkg/data"
+	"idfm/pkg/env"
+	"idfm/pkg/internal/direction"
+	"idfm/pkg/internal/odsql"
+	"idfm/pkg/internal/opendata"
+	"idfm/pkg/internal/referential"
 	"idfm/pkg/internal/utils"
-	"io"
-	"net/http"
 	"net/url"
+	"regexp"
+	"slices"
+	"strings"
 )
 
 const (
-	lineRecordsEndpoint = "https://data.iledefrance-mobilites.fr/api/explore/v2.1/catalog/datasets/referentiel-des-lignes/records"
+	lineRecordsPath = "/api/explore/v2.1/catalog/datasets/referentiel-des-lignes/records"
 )
 
-type linesAPIResponse struct {
-	TotalCount int `json:"total_count"`
-	Results    []struct {
-		IDLine string `json:"id_line"`
-	} `json:"results"`
+var lineRecordsEndpoint = env.IDFM_OPENDATA_URL + lineRecordsPath
+
+// anyOperator designates every operator, as the operator query parameter or among the default operators
+const anyOperator = "*"
+
+// lineIdRegex matches IDFM line IDs, which can be given in place of a line name to pick one of homonymous lines
+var lineIdRegex = regexp.MustCompile(`^(IDFM:)?C[0-9]{5}$`)
+
+// IsLineId tells whether the value is an IDFM line ID, such as "C01742" or "IDFM:C01742"
+func IsLineId(value string) bool {
+	return lineIdRegex.MatchString(value)
 }
 
-type allLinesAPIResponse struct {
-	TotalCount int `json:"total_count"`
-	Results    []struct {
-		NameLine string `json:"name_line"`
-	} `json:"results"`
+// Candidate is one of several lines matching a line name
+type Candidate struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Submode  string `json:"submode,omitempty"`
+	Operator string `json:"operator"`
+	Network  string `json:"network,omitempty"`
+	// Termini come from the direction rules or the trips file, and are omitted when neither knows the line
+	Termini []string `json:"termini,omitempty"`
 }
 
-// GetLineDetailsOrCache retrieves line details from the cache/API
-func GetLineDetailsOrCache(lineType string, lineId string, operator string) (string, error) {
-	lineCacheKey := data.LineCacheKey{LineType: lineType, LineId: lineId, Operator: operator}
+type lineIdRecord struct {
+	IDLine string `json:"id_line"`
+}
+
+type lineNameRecord struct {
+	NameLine string `json:"name_line"`
+}
+
+// GetLineDetailsOrCache retrieves line details from the cache/API. The submode is optional.
+func GetLineDetailsOrCache(lineType string, submode string, lineId string, operator string) (string, error) {
+	lineCacheKey := data.LineCacheKey{LineType: lineType, Submode: submode, LineId: lineId, Operator: operator}
 	cacheItem := data.TypeAndNumberToLineNameCache.Get(lineCacheKey)
 	if cacheItem != nil && !cacheItem.IsExpired() {
 		return cacheItem.Value(), nil
 	}
 
-	// Prepare query parameters
-	params := url.Values{}
-	params.Add("select", "id_line")
-	params.Add("where",
-		fmt.Sprintf("transportmode=\"%s\" AND name_line=\"%s\" AND %s", lineType, lineId, operatorQuery(operator)))
+	var lineIds []string
+	var err error
+	if IsLineId(lineId) {
+		lineIds, err = findLineById(lineType, submode, strings.TrimPrefix(lineId, "IDFM:"))
+		if err != nil {
+			return "", err
+		}
+	}
 
-	resp, err := http.Get(lineRecordsEndpoint + "?" + params.Encode())
-	if err != nil {
-		return "", err
+	if len(lineIds) == 0 {
+		lineIds, err = findLineIds(lineType, submode, lineId, operator)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(lineIds) == 0 {
+		lineNames, truncated, err := getAllLineNames(lineType, submode, operator)
+		if err != nil {
+			return "", err
+		}
+
+		marshal, err := json.Marshal(lineNames)
+		if err != nil {
+			return "", err
+		}
+		message := fmt.Sprintf("%s \"%s\" not found. Available lines: %s", lineType, lineId, marshal)
+		if truncated {
+			message += ". The list was cut at the listing cap"
+		}
+		return "", &utils.RequestError{Message: message}
+	} else if len(lineIds) == 1 {
+		resLineId := lineIds[0]
+		data.TypeAndNumberToLineNameCache.Set(lineCacheKey, resLineId, ttlcache.DefaultTTL)
+		return resLineId, nil
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	candidates, err := getCandidates(lineIds)
 	if err != nil {
 		return "", err
 	}
+	return "", &utils.CandidatesError{
+		Message:    fmt.Sprintf("%s \"%s\" is ambiguous. Pick a line with the operator parameter, or by giving its ID instead of its name", lineType, lineId),
+		Candidates: candidates,
+	}
+}
 
-	var apiResp linesAPIResponse
-	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return "", err
+// findLineById returns the ID of the line when it exists with that mode and submode
+func findLineById(lineType string, submode string, lineId string) ([]string, error) {
+	lines, err := GetLinesByIds([]string{lineId})
+	if err != nil {
+		return nil, err
 	}
+	if line, ok := lines[lineId]; ok && line.IsOfMode(lineType, submode) {
+		return []string{lineId}, nil
+	}
+	return nil, nil
+}
 
-	if apiResp.TotalCount == 0 {
-		allLines, err := getAllLines(lineType, operator)
-		if err != nil {
-			return "", err
-		}
+// getCandidates describes homonymous lines so that the user can pick one
+func getCandidates(lineIds []string) ([]Candidate, error) {
+	lines, err := GetLinesByIds(lineIds)
+	if err != nil {
+		return nil, err
+	}
 
-		lineIds := make([]string, len(allLines.Results))
+	candidates := make([]Candidate, 0, len(lineIds))
+	for _, lineId := range lineIds {
+		line := lines[lineId]
+		candidates = append(candidates, Candidate{
+			ID:       lineId,
+			Name:     line.NameLine,
+			Submode:  line.TransportSubmode,
+			Operator: line.OperatorName,
+			Network:  line.NetworkName,
+			Termini:  termini(lineId),
+		})
+	}
+	return candidates, nil
+}
 
-		for index, result := range allLines.Results {
-			lineIds[index] = result.NameLine
+// termini returns the distinct termini of the line in every direction, or nil when they are not known
+func termini(lineId string) []string {
+	var names []string
+	for _, lineDirection := range direction.Directions(lineId) {
+		for _, name := range lineDirection.Termini {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
 		}
+	}
+	return names
+}
 
-		marshal, err := json.Marshal(lineIds)
-		if err != nil {
-			return "", err
+// findLineIds returns the IDs of the matching lines from the offline referential when it is loaded, or from the API
+func findLineIds(lineType string, submode string, lineId string, operator string) ([]string, error) {
+	if index := referential.Current(); index != nil && len(index.Lines) > 0 {
+		// The offline referential is authoritative, so that unknown lines are told without the portal
+		lines := index.FindLines(lineType, submode, lineId, operators(operator))
+		lineIds := make([]string, len(lines))
+		for i, line := range lines {
+			lineIds[i] = line.IDLine
 		}
-		return "", &utils.RequestError{Message: fmt.Sprintf("%s \"%s\" not found. Available lines: %s", lineType, lineId, marshal)}
-	} else if apiResp.TotalCount == 1 {
-		resLineId := apiResp.Results[0].IDLine
-		data.TypeAndNumberToLineNameCache.Set(lineCacheKey, resLineId, ttlcache.DefaultTTL)
-		return resLineId, nil
+		return lineIds, nil
 	}
 
-	return "", &utils.RequestError{Message: fmt.Sprintf("Invalid transport %s %s", lineType, lineId)}
+	// Prepare query parameters
+	params := url.Values{}
+	params.Add("select", "id_line")
+	params.Add("where", odsql.And(
+		modeQuery(lineType, submode),
+		odsql.Eq("name_line", lineId),
+		operatorQuery(operator),
+	).String())
+
+	apiResp, err := opendata.GetRecords[lineIdRecord](lineRecordsEndpoint, params)
+	if err != nil {
+		return nil, err
+	}
+
+	lineIds := make([]string, len(apiResp.Results))
+	for i, result := range apiResp.Results {
+		lineIds[i] = result.IDLine
+	}
+	return lineIds, nil
 }
 
-// getAllLines retrieves all lines for that type
-func getAllLines(lineType string, operator string) (allLinesAPIResponse, error) {
+// getAllLineNames retrieves the distinct names of all lines for that type and submode, up to the listing cap.
+// It also tells whether the names were cut at the listing cap.
+func getAllLineNames(lineType string, submode string, operator string) ([]string, bool, error) {
+	if index := referential.Current(); index != nil && len(index.Lines) > 0 {
+		return index.LineNames(lineType, submode, operators(operator)), false, nil
+	}
+
 	// Prepare query parameters
 	params := url.Values{}
 	params.Add("select", "name_line")
-	params.Add("where",
-		fmt.Sprintf("transportmode=\"%s\" AND %s", lineType, operatorQuery(operator)))
-	params.Add("limit", "100")
+	params.Add("where", odsql.And(
+		modeQuery(lineType, submode),
+		operatorQuery(operator),
+	).String())
+	params.Add("order_by", "name_line")
 
-	resp, err := http.Get(lineRecordsEndpoint + "?" + params.Encode())
+	records, totalCount, err := opendata.GetAllRecords[lineNameRecord](lineRecordsEndpoint, params, env.IDFM_LISTING_CAP)
 	if err != nil {
-		return allLinesAPIResponse{}, err
+		return nil, false, err
+	}
+	truncated := opendata.Truncated(fmt.Sprintf("Names of the %s lines", lineType), len(records), totalCount)
+
+	lineNames := make([]string, 0, len(records))
+	for _, record := range records {
+		if !slices.Contains(lineNames, record.NameLine) {
+			lineNames = append(lineNames, record.NameLine)
+		}
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	return lineNames, truncated, nil
+}
+
+// GetLinesByIds retrieves the details of the given lines from the cache, the offline referential when it is loaded,
+// or the API. Unknown lines are left out.
+func GetLinesByIds(lineIds []string) (map[string]referential.Line, error) {
+	lines := make(map[string]referential.Line, len(lineIds))
+	var missingIds []string
+	missing := map[string]bool{}
+
+	index := referential.Current()
+	for _, lineId := range lineIds {
+		if cacheItem := data.LineByIdCache.Get(lineId); cacheItem != nil && !cacheItem.IsExpired() {
+			lines[lineId] = cacheItem.Value()
+			continue
+		}
+		if index != nil {
+			if line, ok := index.LineByID(lineId); ok {
+				lines[lineId] = line
+				continue
+			}
+		}
+		if !missing[lineId] {
+			missing[lineId] = true
+			missingIds = append(missingIds, lineId)
+		}
+	}
+
+	if len(missingIds) == 0 || (index != nil && len(index.Lines) > 0) {
+		return lines, nil
+	}
+
+	// Prepare query parameters
+	params := url.Values{}
+	params.Add("where", odsql.In("id_line", missingIds...).String())
+
+	records, totalCount, err := opendata.GetAllRecords[referential.Line](lineRecordsEndpoint, params, len(missingIds))
 	if err != nil {
-		return allLinesAPIResponse{}, err
+		return nil, err
 	}
+	opendata.Truncated("Details of the lines", len(records), totalCount)
 
-	var apiResp allLinesAPIResponse
-	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return apiResp, err
+	for _, record := range records {
+		lines[record.IDLine] = record
+		data.LineByIdCache.Set(record.IDLine, record, ttlcache.DefaultTTL)
 	}
 
-	return apiResp, nil
+	return lines, nil
 }
 
-func operatorQuery(operator string) string {
-	var operatorQuery string
+// operators returns the requested operator, or the default ones. nil means any operator.
+func operators(operator string) []string {
+	requested := env.IDFM_DEFAULT_OPERATORS
 	if operator != "" {
-		operatorQuery = fmt.Sprintf("operatorname=\"%s\"", operator)
-	} else {
-		operatorQuery = "(operatorname=\"RATP\" OR operatorname=\"SNCF\")"
+		requested = []string{operator}
+	}
+	if slices.Contains(requested, anyOperator) {
+		return nil
+	}
+	return requested
+}
+
+func operatorQuery(operator string) odsql.Expr {
+	if ops := operators(operator); ops != nil {
+		return odsql.In("operatorname", ops...)
+	}
+	return odsql.True
+}
+
+// modeQuery filters lines on their mode and submode, either being any when empty
+func modeQuery(lineType string, submode string) odsql.Expr {
+	var filters []odsql.Expr
+	if lineType != "" {
+		filters = append(filters, odsql.Eq("transportmode", lineType))
+	}
+	if submode != "" {
+		filters = append(filters, odsql.Eq("transportsubmode", submode))
 	}
-	return operatorQuery
+	return odsql.And(filters...)
 }
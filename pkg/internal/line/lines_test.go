@@ -0,0 +1,83 @@
+package line
+
+import (
+	"errors"
+	"idfm/pkg/env"
+	"idfm/pkg/internal/referential"
+	"idfm/pkg/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// downPortal points the line records at an opendata portal failing every request, and counts the requests
+func downPortal(t *testing.T) *atomic.Int32 {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	endpoint := lineRecordsEndpoint
+	lineRecordsEndpoint = server.URL + lineRecordsPath
+	t.Cleanup(func() { lineRecordsEndpoint = endpoint })
+	return &requests
+}
+
+// loadLines loads an offline referential made of the given referentiel-des-lignes JSON export
+func loadLines(t *testing.T, lines string) {
+	path := filepath.Join(t.TempDir(), "lines.json")
+	if err := os.WriteFile(path, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env.IDFM_LINES_DATASET_FILE = path
+	t.Cleanup(func() {
+		env.IDFM_LINES_DATASET_FILE = ""
+		_ = referential.Load()
+	})
+	if err := referential.Load(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetLineDetailsOffline(t *testing.T) {
+	requests := downPortal(t)
+	loadLines(t, `[{"id_line":"C01742","name_line":"A","transportmode":"rail","operatorname":"RATP"},`+
+		`{"id_line":"C01371","name_line":"1","transportmode":"metro","operatorname":"RATP"}]`)
+
+	tests := []struct {
+		name   string
+		lineId string
+		want   string
+	}{
+		{"name", "A", "C01742"},
+		{"ID", "C01742", "C01742"},
+		{"unknown name", "Z", ""},
+		{"unknown ID", "C09999", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lineId, err := GetLineDetailsOrCache("rail", "", test.lineId, "")
+			if test.want != "" {
+				if err != nil || lineId != test.want {
+					t.Errorf("GetLineDetailsOrCache(%s) = %s, %v, want %s", test.lineId, lineId, err, test.want)
+				}
+				return
+			}
+			var requestErr *utils.RequestError
+			if !errors.As(err, &requestErr) || !strings.Contains(requestErr.Message, `["A"]`) {
+				t.Errorf("GetLineDetailsOrCache(%s) error = %v, want the available lines", test.lineId, err)
+			}
+		})
+	}
+
+	if requests.Load() != 0 {
+		t.Errorf("portal requested %d times, want none while the offline referential is loaded", requests.Load())
+	}
+}
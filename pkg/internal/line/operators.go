@@ -0,0 +1,64 @@
+package line
+
+import (
+	"cmp"
+	"idfm/pkg/env"
+	"idfm/pkg/internal/opendata"
+	"idfm/pkg/internal/referential"
+	"net/url"
+	"slices"
+)
+
+// Operator is an operator of the referential along with the number of lines it runs
+type Operator struct {
+	Name  string `json:"name"`
+	Lines int    `json:"lines"`
+}
+
+type operatorRecord struct {
+	OperatorName string `json:"operatorname"`
+	Lines        int    `json:"lines"`
+}
+
+// ListOperators retrieves the operators running lines of that type and submode (all when empty), busiest first
+func ListOperators(lineType string, submode string) ([]Operator, error) {
+	operators := []Operator{}
+
+	if index := referential.Current(); index != nil && len(index.Lines) > 0 {
+		lineCounts := map[string]int{}
+		for _, line := range index.Lines {
+			if line.IsOfMode(lineType, submode) {
+				lineCounts[line.OperatorName]++
+			}
+		}
+		for name, lines := range lineCounts {
+			operators = append(operators, Operator{Name: name, Lines: lines})
+		}
+		sortOperators(operators)
+		return operators, nil
+	}
+
+	// Prepare query parameters
+	params := url.Values{}
+	params.Add("select", "operatorname, count(*) as lines")
+	params.Add("where", modeQuery(lineType, submode).String())
+	params.Add("group_by", "operatorname")
+
+	records, err := opendata.GetAllGroups[operatorRecord](lineRecordsEndpoint, params, env.IDFM_LISTING_CAP)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		operators = append(operators, Operator{Name: record.OperatorName, Lines: record.Lines})
+	}
+	sortOperators(operators)
+	return operators, nil
+}
+
+// sortOperators orders operators by number of lines, then by name
+func sortOperators(operators []Operator) {
+	slices.SortStableFunc(operators, func(a, b Operator) int {
+		return cmp.Or(cmp.Compare(b.Lines, a.Lines), cmp.Compare(a.Name, b.Name))
+	})
+}
@@ -0,0 +1,36 @@
+package line
+
+import (
+	"idfm/pkg/internal/direction"
+)
+
+// Resolver resolves lines against the IDFM referential
+type Resolver struct{}
+
+func (Resolver) GetLineDetailsOrCache(lineType string, submode string, lineId string, operator string) (string, error) {
+	return GetLineDetailsOrCache(lineType, submode, lineId, operator)
+}
+
+func (Resolver) GetLine(lineType string, submode string, lineId string, operator string) (Details, error) {
+	return GetLine(lineType, submode, lineId, operator)
+}
+
+func (Resolver) ListLines(lineType string, submode string, operator string) ([]Details, error) {
+	return ListLines(lineType, submode, operator)
+}
+
+func (Resolver) GetLineDetailsByIds(lineIds []string) (map[string]Details, error) {
+	return GetLineDetailsByIds(lineIds)
+}
+
+func (Resolver) ListOperators(lineType string, submode string) ([]Operator, error) {
+	return ListOperators(lineType, submode)
+}
+
+func (Resolver) GetDirections(lineType string, submode string, lineId string, operator string) ([]direction.Direction, error) {
+	return GetDirections(lineType, submode, lineId, operator)
+}
+
+func (Resolver) MatchDirection(lineId string, value string) (string, error) {
+	return MatchDirection(lineId, value)
+}
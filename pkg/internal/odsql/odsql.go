@@ -0,0 +1,86 @@
+// Package odsql builds where clauses in the Opendatasoft query language (ODSQL) used by the opendata referential.
+// User input only ever ends up in escaped string literals, so that it cannot change the meaning of a query.
+// Field names are expected to be constants of the code base.
+package odsql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Expr is a boolean ODSQL expression
+type Expr struct {
+	clause string
+}
+
+var (
+	// True matches every record
+	True = Expr{clause: "true"}
+	// False matches no record
+	False = Expr{clause: "false"}
+)
+
+// String returns the ODSQL text of the expression
+func (e Expr) String() string {
+	return e.clause
+}
+
+// Literal quotes a string literal, escaping backslashes and double quotes
+func Literal(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+	return `"` + escaped + `"`
+}
+
+// Eq matches records whose field equals the value
+func Eq(field string, value string) Expr {
+	return Expr{clause: field + "=" + Literal(value)}
+}
+
+// In matches records whose field equals any of the values
+func In(field string, values ...string) Expr {
+	exprs := make([]Expr, len(values))
+	for index, value := range values {
+		exprs[index] = Eq(field, value)
+	}
+	return Or(exprs...)
+}
+
+// Search matches records whose field contains the words of the text, regardless of case and accents
+func Search(field string, text string) Expr {
+	return Expr{clause: "search(" + field + ", " + Literal(text) + ")"}
+}
+
+// WithinDistance matches records whose geo point field is at most the given distance (in meters) from a point
+func WithinDistance(field string, lat float64, lon float64, meters float64) Expr {
+	point := "POINT(" + formatFloat(lon) + " " + formatFloat(lat) + ")"
+	return Expr{clause: "within_distance(" + field + ", geom'" + point + "', " + formatFloat(meters) + "m)"}
+}
+
+// And matches records matching every expression
+func And(exprs ...Expr) Expr {
+	return join(" AND ", True, exprs)
+}
+
+// Or matches records matching any of the expressions
+func Or(exprs ...Expr) Expr {
+	return join(" OR ", False, exprs)
+}
+
+func join(operator string, empty Expr, exprs []Expr) Expr {
+	switch len(exprs) {
+	case 0:
+		return empty
+	case 1:
+		return exprs[0]
+	}
+
+	clauses := make([]string, len(exprs))
+	for index, expr := range exprs {
+		clauses[index] = expr.clause
+	}
+	return Expr{clause: "(" + strings.Join(clauses, operator) + ")"}
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,103 @@
+package odsql
+
+import (
+	"testing"
+)
+
+// hostileNames are stop names that would change the meaning of a query if they were not escaped
+var hostileNames = []struct {
+	name    string
+	value   string
+	literal string
+}{
+	{"plain", "Châtelet", `"Châtelet"`},
+	{"double quote", `Gare "Nord"`, `"Gare \"Nord\""`},
+	{"backslash", `Gare\Nord`, `"Gare\\Nord"`},
+	{"trailing backslash", `Gare\`, `"Gare\\"`},
+	{"escaped quote", `Gare\"`, `"Gare\\\""`},
+	{"single quote", "Porte d'Orléans", `"Porte d'Orléans"`},
+	{"injection", `" OR "1"="1`, `"\" OR \"1\"=\"1"`},
+	{"unicode", "Saint-Germain-des-Prés œ 東京 🚇", `"Saint-Germain-des-Prés œ 東京 🚇"`},
+	{"empty", "", `""`},
+}
+
+func TestLiteral(t *testing.T) {
+	for _, test := range hostileNames {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Literal(test.value); got != test.literal {
+				t.Errorf("Literal(%q) = %s, want %s", test.value, got, test.literal)
+			}
+		})
+	}
+}
+
+func TestEq(t *testing.T) {
+	for _, test := range hostileNames {
+		t.Run(test.name, func(t *testing.T) {
+			want := "stop_name=" + test.literal
+			if got := Eq("stop_name", test.value).String(); got != want {
+				t.Errorf("Eq(%q) = %s, want %s", test.value, got, want)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	for _, test := range hostileNames {
+		t.Run(test.name, func(t *testing.T) {
+			want := "search(stop_name, " + test.literal + ")"
+			if got := Search("stop_name", test.value).String(); got != want {
+				t.Errorf("Search(%q) = %s, want %s", test.value, got, want)
+			}
+		})
+	}
+}
+
+func TestIn(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   string
+	}{
+		{"none", nil, `false`},
+		{"single", []string{`" OR "1"="1`}, `id="\" OR \"1\"=\"1"`},
+		{"several", []string{`a"`, `b\`, "c'"}, `(id="a\"" OR id="b\\" OR id="c'")`},
+		{"unicode", []string{"Hôtel de Ville", "東京"}, `(id="Hôtel de Ville" OR id="東京")`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := In("id", test.values...).String(); got != test.want {
+				t.Errorf("In(%q) = %s, want %s", test.values, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAndOr(t *testing.T) {
+	tests := []struct {
+		name string
+		expr Expr
+		want string
+	}{
+		{"empty and", And(), `true`},
+		{"empty or", Or(), `false`},
+		{"single and", And(Eq("a", "1")), `a="1"`},
+		{"nested", And(Eq("a", `"`), Or(Eq("b", "x"), Search("c", `\`))), `(a="\"" AND (b="x" OR search(c, "\\")))`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.expr.String(); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWithinDistance(t *testing.T) {
+	want := `within_distance(geo, geom'POINT(2.3473 48.8589)', 500m)`
+	if got := WithinDistance("geo", 48.8589, 2.3473, 500).String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package opendata
+
+import (
+	"idfm/pkg/internal/upstream"
+	"log"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// pageSize is the largest page the records API accepts
+	pageSize = 100
+	// recordsWindow is the largest offset + limit the records API accepts
+	recordsWindow = 10000
+)
+
+// RecordsResponse represents a page of the opendata records API
+type RecordsResponse[T any] struct {
+	TotalCount int `json:"total_count"`
+	Results    []T `json:"results"`
+}
+
+// GetRecords retrieves a single page of records matching the given query parameters
+func GetRecords[T any](endpoint string, params url.Values) (RecordsResponse[T], error) {
+	var apiResp RecordsResponse[T]
+	if err := upstream.OpenData.GetJSON(endpoint+"?"+params.Encode(), &apiResp); err != nil {
+		return RecordsResponse[T]{}, err
+	}
+	return apiResp, nil
+}
+
+// GetAllRecords pages through the records matching the given query parameters, up to maxRecords of them.
+// It also returns the total count of matching records, which may be higher than the number of records retrieved.
+func GetAllRecords[T any](endpoint string, params url.Values, maxRecords int) ([]T, int, error) {
+	maxRecords = min(maxRecords, recordsWindow)
+
+	var records []T
+	totalCount := 0
+	for offset := 0; offset < maxRecords; offset += pageSize {
+		pageParams := url.Values{}
+		for name, values := range params {
+			pageParams[name] = values
+		}
+		pageParams.Set("limit", strconv.Itoa(min(pageSize, maxRecords-offset)))
+		pageParams.Set("offset", strconv.Itoa(offset))
+
+		page, err := GetRecords[T](endpoint, pageParams)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		records = append(records, page.Results...)
+		totalCount = page.TotalCount
+		if len(page.Results) == 0 || offset+len(page.Results) >= totalCount {
+			break
+		}
+	}
+
+	return records, totalCount, nil
+}
+
+// GetAllGroups pages through the groups of a group_by query, up to maxGroups of them.
+// The total count of a grouped query is not the number of groups, so pages are requested until a short one comes back.
+func GetAllGroups[T any](endpoint string, params url.Values, maxGroups int) ([]T, error) {
+	maxGroups = min(maxGroups, recordsWindow)
+
+	var groups []T
+	for offset := 0; offset < maxGroups; offset += pageSize {
+		pageParams := url.Values{}
+		for name, values := range params {
+			pageParams[name] = values
+		}
+		limit := min(pageSize, maxGroups-offset)
+		pageParams.Set("limit", strconv.Itoa(limit))
+		pageParams.Set("offset", strconv.Itoa(offset))
+
+		page, err := GetRecords[T](endpoint, pageParams)
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, page.Results...)
+		if len(page.Results) < limit {
+			return groups, nil
+		}
+	}
+
+	log.Printf("Groups of %s cut at %d", endpoint, len(groups))
+	return groups, nil
+}
+
+// Truncated tells whether a listing was cut short of the total count of matching records, and logs it so that the cap
+// can be raised. listing describes what was listed, such as "Names of the lines of type bus".
+func Truncated(listing string, retrieved int, totalCount int) bool {
+	if retrieved >= totalCount {
+		return false
+	}
+	log.Printf("%s cut at %d of %d records", listing, retrieved, totalCount)
+	return true
+}
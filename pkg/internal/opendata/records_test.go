@@ -0,0 +1,64 @@
+package opendata
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+type group struct {
+	Name string `json:"name"`
+}
+
+// groupServer serves a grouped query of count groups, with a total count of records higher than the number of groups
+func groupServer(t *testing.T, count int) (*httptest.Server, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+
+		page := RecordsResponse[group]{TotalCount: count * 10, Results: []group{}}
+		for index := offset; index < min(offset+limit, count); index++ {
+			page.Results = append(page.Results, group{Name: strconv.Itoa(index)})
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestGetAllGroups(t *testing.T) {
+	tests := []struct {
+		name      string
+		count     int
+		maxGroups int
+		want      int
+		requests  int
+	}{
+		{"single page", 42, 5000, 42, 1},
+		{"several pages", 250, 5000, 250, 3},
+		{"full last page", 200, 5000, 200, 3},
+		{"capped", 250, 150, 150, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := groupServer(t, test.count)
+
+			groups, err := GetAllGroups[group](server.URL, url.Values{"group_by": {"name"}}, test.maxGroups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(groups) != test.want {
+				t.Errorf("got %d groups, want %d", len(groups), test.want)
+			}
+			if *requests != test.requests {
+				t.Errorf("made %d requests, want %d", *requests, test.requests)
+			}
+		})
+	}
+}
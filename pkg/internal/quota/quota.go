@@ -0,0 +1,154 @@
+package quota
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"idfm/pkg/env"
+	"idfm/pkg/internal/utils"
+	"math"
+	"sync"
+	"time"
+)
+
+// Level tells how much of the daily quota of an API key has been used
+type Level int
+
+const (
+	// Normal means the key can be used freely
+	Normal Level = iota
+	// Soft means cached or stale data should be preferred over new upstream calls
+	Soft
+	// Hard means no upstream call should be made until the quota resets
+	Hard
+)
+
+// ExhaustedError is returned instead of calling PRIM once the hard threshold has been reached
+type ExhaustedError struct {
+	ResetAt time.Time
+}
+
+func (e *ExhaustedError) Error() string {
+	return fmt.Sprintf("PRIM daily quota exhausted, it resets at %s", e.ResetAt.Format(time.RFC3339))
+}
+
+// RetryAfter returns the number of seconds until the quota resets
+func (e *ExhaustedError) RetryAfter() int {
+	return int(math.Ceil(time.Until(e.ResetAt).Seconds()))
+}
+
+// maxTrackedKeys caps the number of client API keys whose usage is tracked, the least used ones being evicted first
+const maxTrackedKeys = 1000
+
+var (
+	mu     sync.Mutex
+	day    string
+	counts = map[string]int{}
+	// pinned are the server API keys, which are never evicted
+	pinned = map[string]bool{}
+)
+
+var usedGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "idfm",
+	Name:      "quota_used",
+	Help:      "Stop monitoring calls made today (Europe/Paris), per API key fingerprint",
+}, []string{"key"})
+
+func init() {
+	registerLimitMetric("soft", env.IDFM_QUOTA_SOFT_LIMIT)
+	registerLimitMetric("hard", env.IDFM_QUOTA_HARD_LIMIT)
+}
+
+func registerLimitMetric(threshold string, limit int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "idfm",
+		Name:      "quota_limit",
+		Help:      "Daily quota threshold per API key (0 when disabled)",
+		ConstLabels: prometheus.Labels{
+			"threshold": threshold,
+		},
+	}, func() float64 {
+		return float64(limit)
+	})
+}
+
+// Pin keeps the usage of a server API key tracked whatever the number of client keys
+func Pin(apiKey string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	pinned[apiKey] = true
+}
+
+// Record counts one outbound stop monitoring call made with the given API key
+func Record(apiKey string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	rollOver()
+	if _, tracked := counts[apiKey]; !tracked && len(counts) >= maxTrackedKeys+len(pinned) {
+		evictLeastUsed()
+	}
+	counts[apiKey]++
+	usedGauge.WithLabelValues(Fingerprint(apiKey)).Set(float64(counts[apiKey]))
+}
+
+// Used returns the number of stop monitoring calls made today with the given API key
+func Used(apiKey string) int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	rollOver()
+	return counts[apiKey]
+}
+
+// Check returns the quota level of the given API key
+func Check(apiKey string) Level {
+	used := Used(apiKey)
+
+	if env.IDFM_QUOTA_HARD_LIMIT > 0 && used >= env.IDFM_QUOTA_HARD_LIMIT {
+		return Hard
+	}
+	if env.IDFM_QUOTA_SOFT_LIMIT > 0 && used >= env.IDFM_QUOTA_SOFT_LIMIT {
+		return Soft
+	}
+	return Normal
+}
+
+// ResetAt returns the time at which daily quotas reset, i.e. the next midnight in Europe/Paris
+func ResetAt() time.Time {
+	year, month, dayOfMonth := time.Now().In(utils.Paris).Date()
+	return time.Date(year, month, dayOfMonth+1, 0, 0, 0, 0, utils.Paris)
+}
+
+// Fingerprint identifies an API key in metrics and logs without disclosing it
+func Fingerprint(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// evictLeastUsed stops tracking the least used client API key. The caller must hold mu.
+func evictLeastUsed() {
+	leastUsed, found := "", false
+	for apiKey, used := range counts {
+		if !pinned[apiKey] && (!found || used < counts[leastUsed]) {
+			leastUsed, found = apiKey, true
+		}
+	}
+	if found {
+		delete(counts, leastUsed)
+		usedGauge.DeleteLabelValues(Fingerprint(leastUsed))
+	}
+}
+
+// rollOver resets the counters when the day changed in Europe/Paris. The caller must hold mu.
+func rollOver() {
+	today := time.Now().In(utils.Paris).Format(time.DateOnly)
+	if today != day {
+		day = today
+		counts = map[string]int{}
+		usedGauge.Reset()
+	}
+}
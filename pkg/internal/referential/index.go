@@ -0,0 +1,160 @@
+package referential
+
+import (
+	"cmp"
+	"idfm/pkg/env"
+	"idfm/pkg/internal/geo"
+	"log"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Index is an in-memory copy of the lines and stops datasets
+type Index struct {
+	Lines []Line
+	Stops []Stop
+
+	linesById   map[string]Line
+	stopsByLine map[string][]Stop
+	stopsGrid   *geo.Grid[Stop]
+	// terminiByLine are the trip headsigns of each line by GTFS direction, most frequent first
+	terminiByLine map[string]map[string][]string
+}
+
+// current is the latest loaded index, nil while no dataset file is configured
+var current atomic.Pointer[Index]
+
+// Current returns the offline referential, or nil when it is not available
+func Current() *Index {
+	return current.Load()
+}
+
+// Enabled tells whether dataset files are configured
+func Enabled() bool {
+	return env.IDFM_LINES_DATASET_FILE != "" || env.IDFM_STOPS_DATASET_FILE != "" || env.IDFM_TRIPS_DATASET_FILE != ""
+}
+
+// Load reads the configured dataset files into a new index
+func Load() error {
+	index := &Index{}
+
+	if env.IDFM_LINES_DATASET_FILE != "" {
+		lines, err := loadFile[Line](env.IDFM_LINES_DATASET_FILE)
+		if err != nil {
+			return err
+		}
+		index.Lines = lines
+	}
+
+	if env.IDFM_STOPS_DATASET_FILE != "" {
+		stops, err := loadFile[Stop](env.IDFM_STOPS_DATASET_FILE)
+		if err != nil {
+			return err
+		}
+		index.Stops = stops
+	}
+
+	if env.IDFM_TRIPS_DATASET_FILE != "" {
+		// Trips are only kept as termini, the feed counting hundreds of thousands of them
+		termini, err := loadTermini(env.IDFM_TRIPS_DATASET_FILE)
+		if err != nil {
+			return err
+		}
+		index.terminiByLine = termini
+	}
+
+	index.linesById = make(map[string]Line)
+	for _, line := range index.Lines {
+		index.linesById[line.IDLine] = line
+	}
+
+	index.stopsByLine = make(map[string][]Stop)
+	for _, stop := range index.Stops {
+		index.stopsByLine[stop.LineID()] = append(index.stopsByLine[stop.LineID()], stop)
+	}
+
+	index.stopsGrid = geo.NewGrid(index.Stops, func(stop Stop) geo.Point {
+		return geo.Point{Lat: float64(stop.StopLat), Lon: float64(stop.StopLon)}
+	})
+
+	current.Store(index)
+	log.Printf("Offline referential loaded: %d lines, %d stops, termini of %d lines", len(index.Lines), len(index.Stops), len(index.terminiByLine))
+	return nil
+}
+
+// Refresh reloads the dataset files periodically, keeping the previous index when a reload fails
+func Refresh(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		if err := Load(); err != nil {
+			log.Printf("Offline referential refresh failed, keeping the previous one: %s", err)
+		}
+	}
+}
+
+// indexTermini orders the headsign counts of the trips of each line by direction, most frequent first
+func indexTermini(counts map[string]map[string]map[string]int) map[string]map[string][]string {
+	termini := make(map[string]map[string][]string, len(counts))
+	for lineId, directions := range counts {
+		termini[lineId] = make(map[string][]string, len(directions))
+		for directionId, headsigns := range directions {
+			names := make([]string, 0, len(headsigns))
+			for name := range headsigns {
+				names = append(names, name)
+			}
+			slices.SortFunc(names, func(a, b string) int {
+				return cmp.Or(cmp.Compare(headsigns[b], headsigns[a]), cmp.Compare(a, b))
+			})
+			termini[lineId][directionId] = names
+		}
+	}
+	return termini
+}
+
+// FindLines returns the lines of the given mode, submode (any when empty) and name, run by any of the operators (any when nil)
+func (idx *Index) FindLines(lineType string, submode string, lineName string, operators []string) []Line {
+	var lines []Line
+	for _, line := range idx.Lines {
+		if line.IsOfMode(lineType, submode) && line.NameLine == lineName && line.IsRunBy(operators) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// LineNames returns the distinct names of the lines of the given mode and submode (any when empty), run by any of the operators (any when nil)
+func (idx *Index) LineNames(lineType string, submode string, operators []string) []string {
+	var lineNames []string
+	for _, line := range idx.Lines {
+		if line.IsOfMode(lineType, submode) && line.IsRunBy(operators) && !slices.Contains(lineNames, line.NameLine) {
+			lineNames = append(lineNames, line.NameLine)
+		}
+	}
+	slices.Sort(lineNames)
+	return lineNames
+}
+
+// LineByID returns the line with the given ID, such as "C01742"
+func (idx *Index) LineByID(lineId string) (Line, bool) {
+	line, ok := idx.linesById[lineId]
+	return line, ok
+}
+
+// StopsOfLine returns the stops served by the line, identified without its "IDFM:" prefix
+func (idx *Index) StopsOfLine(lineId string) []Stop {
+	return idx.stopsByLine[lineId]
+}
+
+// Termini returns the termini of the line by GTFS direction ("0" or "1"), most frequent first,
+// or nil when the trips file is not loaded or does not know the line
+func (idx *Index) Termini(lineId string) map[string][]string {
+	return idx.terminiByLine[lineId]
+}
+
+// StopsWithin returns the stops at most radius meters away from the center, closest first
+func (idx *Index) StopsWithin(center geo.Point, radius float64) []geo.Hit[Stop] {
+	return idx.stopsGrid.Within(center, radius)
+}
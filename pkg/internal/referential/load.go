@@ -0,0 +1,152 @@
+package referential
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// loadFile reads the records of a dataset exported by the opendata portal, in JSON or CSV (semicolon-separated).
+// GTFS files are read by loadTermini instead.
+func loadFile[T any](path string) ([]T, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var records []T
+		if err := json.NewDecoder(file).Decode(&records); err != nil {
+			return nil, fmt.Errorf("invalid JSON export %s: %w", path, err)
+		}
+		return records, nil
+	case ".csv":
+		records, err := decodeCSV[T](file, ';')
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV export %s: %w", path, err)
+		}
+		return records, nil
+	}
+	return nil, fmt.Errorf("unsupported export format %s: expected a .json or .csv file", path)
+}
+
+// decodeCSV maps each CSV row to a record through the JSON field names of the record
+func decodeCSV[T any](reader io.Reader, separator rune) ([]T, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.Comma = separator
+	csvReader.LazyQuotes = true
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, err
+	}
+	// Exports may start with a byte order mark
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	var records []T
+	for {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fields := make(map[string]string, len(header))
+		for index, name := range header {
+			if index < len(row) {
+				fields[name] = row[index]
+			}
+		}
+
+		encoded, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		var record T
+		if err := json.Unmarshal(encoded, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// loadTermini collects the distinct headsigns of the trips of each line by direction from a GTFS trips.txt file,
+// most frequent first. Trips are counted as they are read, rather than decoded into records first.
+func loadTermini(path string) (map[string]map[string][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	counts := map[string]map[string]map[string]int{}
+	err = readTrips(file, func(trip Trip) {
+		if trip.TripHeadsign == "" {
+			return
+		}
+		lineId := trip.LineID()
+		if counts[lineId] == nil {
+			counts[lineId] = map[string]map[string]int{}
+		}
+		if counts[lineId][trip.DirectionID] == nil {
+			counts[lineId][trip.DirectionID] = map[string]int{}
+		}
+		counts[lineId][trip.DirectionID][trip.TripHeadsign]++
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid GTFS file %s: %w", path, err)
+	}
+	return indexTermini(counts), nil
+}
+
+// tripColumns are the columns of a GTFS trips.txt file read into a Trip
+var tripColumns = []string{"route_id", "direction_id", "trip_headsign"}
+
+// readTrips reads the trips of a GTFS trips.txt file, passing each of them to visit.
+// Columns are looked up once in the header and read directly.
+func readTrips(reader io.Reader, visit func(trip Trip)) error {
+	csvReader := csv.NewReader(reader)
+	csvReader.LazyQuotes = true
+	csvReader.ReuseRecord = true
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return err
+	}
+	// Exports may start with a byte order mark
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	columns := make([]int, len(tripColumns))
+	for index, name := range tripColumns {
+		columns[index] = slices.Index(header, name)
+		if columns[index] < 0 {
+			return fmt.Errorf("missing column %s", name)
+		}
+	}
+	field := func(row []string, index int) string {
+		if columns[index] < len(row) {
+			return row[columns[index]]
+		}
+		return ""
+	}
+
+	for {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		visit(Trip{RouteID: field(row, 0), DirectionID: field(row, 1), TripHeadsign: field(row, 2)})
+	}
+}
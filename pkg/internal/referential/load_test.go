@@ -0,0 +1,45 @@
+package referential
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadTermini(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trips.txt")
+	trips := "\ufefftrip_id,direction_id,route_id,service_id,trip_headsign\n" +
+		"1,0,IDFM:C01742,S,Saint-Germain-en-Laye\n" +
+		"2,0,IDFM:C01742,S,Cergy le Haut\n" +
+		"3,0,IDFM:C01742,S,Cergy le Haut\n" +
+		"4,1,IDFM:C01742,S,\"Marne-la-Vallée Chessy\"\n" +
+		"5,1,IDFM:C01742,S,\n" +
+		"6,0,IDFM:C01743,S,Robinson\n"
+	if err := os.WriteFile(path, []byte(trips), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	termini, err := loadTermini(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string][]string{
+		"C01742": {"0": {"Cergy le Haut", "Saint-Germain-en-Laye"}, "1": {"Marne-la-Vallée Chessy"}},
+		"C01743": {"0": {"Robinson"}},
+	}
+	if !reflect.DeepEqual(termini, want) {
+		t.Errorf("termini = %v, want %v", termini, want)
+	}
+}
+
+func TestLoadTerminiMissingColumn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trips.txt")
+	if err := os.WriteFile(path, []byte("trip_id,route_id,trip_headsign\n1,IDFM:C01742,Cergy le Haut\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadTermini(path); err == nil {
+		t.Error("got no error for a file without direction_id")
+	}
+}
@@ -0,0 +1,114 @@
+package referential
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Line is a record of the referentiel-des-lignes dataset
+type Line struct {
+	IDLine            string `json:"id_line"`
+	NameLine          string `json:"name_line"`
+	ShortNameLine     string `json:"shortname_line"`
+	TransportMode     string `json:"transportmode"`
+	TransportSubmode  string `json:"transportsubmode"`
+	OperatorName      string `json:"operatorname"`
+	NetworkName       string `json:"networkname"`
+	ColourWebHexa     string `json:"colourweb_hexa"`
+	TextColourWebHexa string `json:"textcolourweb_hexa"`
+	Accessibility     string `json:"accessibility"`
+	Picto             Picto  `json:"picto"`
+}
+
+// Stop is a record of the arrets-lignes dataset, i.e. a stop served by a line
+type Stop struct {
+	// ID is the ID of the line serving the stop, such as "IDFM:C01742"
+	ID            string     `json:"id"`
+	RouteLongName string     `json:"route_long_name"`
+	StopID        string     `json:"stop_id"`
+	StopName      string     `json:"stop_name"`
+	StopLon       Coordinate `json:"stop_lon"`
+	StopLat       Coordinate `json:"stop_lat"`
+	OperatorName  string     `json:"operatorname"`
+	ShortName     string     `json:"shortname"`
+	Mode          string     `json:"mode"`
+	Town          string     `json:"nom_commune"`
+	InseeCode     string     `json:"code_insee"`
+}
+
+// Trip is a row of the trips.txt file of the IDFM GTFS feed, read from its route_id, direction_id and trip_headsign columns
+type Trip struct {
+	// RouteID is the ID of the line of the trip, such as "IDFM:C01742"
+	RouteID string
+	// DirectionID is the GTFS direction of the trip, "0" or "1"
+	DirectionID  string
+	TripHeadsign string
+}
+
+// IsOfMode tells whether the line is of the given mode and submode, either being any when empty
+func (l Line) IsOfMode(lineType string, submode string) bool {
+	return (lineType == "" || l.TransportMode == lineType) && (submode == "" || l.TransportSubmode == submode)
+}
+
+// IsRunBy tells whether the line is run by any of the operators, or by any operator at all when nil
+func (l Line) IsRunBy(operators []string) bool {
+	return operators == nil || slices.Contains(operators, l.OperatorName)
+}
+
+// LineID returns the ID of the line serving the stop, without its "IDFM:" prefix
+func (s Stop) LineID() string {
+	return strings.TrimPrefix(s.ID, "IDFM:")
+}
+
+// LineID returns the ID of the line of the trip, without its "IDFM:" prefix
+func (t Trip) LineID() string {
+	return strings.TrimPrefix(t.RouteID, "IDFM:")
+}
+
+// Picto is the URL of a line picture. The referential serves it as a file object in JSON and as a plain URL in CSV.
+type Picto string
+
+func (p *Picto) UnmarshalJSON(data []byte) error {
+	var file struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(data, &file); err == nil {
+		*p = Picto(file.URL)
+		return nil
+	}
+
+	var url string
+	if err := json.Unmarshal(data, &url); err != nil {
+		return err
+	}
+	*p = Picto(url)
+	return nil
+}
+
+// Coordinate is a latitude or longitude, served either as a number or as a string
+type Coordinate float64
+
+func (c *Coordinate) UnmarshalJSON(data []byte) error {
+	var value float64
+	if err := json.Unmarshal(data, &value); err == nil {
+		*c = Coordinate(value)
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	if text == "" {
+		*c = 0
+		return nil
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return err
+	}
+	*c = Coordinate(value)
+	return nil
+}
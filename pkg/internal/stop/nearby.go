@@ -0,0 +1,76 @@
+package stop
+
+import (
+	"cmp"
+	"idfm/pkg/env"
+	"idfm/pkg/internal/geo"
+	"idfm/pkg/internal/odsql"
+	"idfm/pkg/internal/opendata"
+	"idfm/pkg/internal/referential"
+	"math"
+	"net/url"
+)
+
+// NearbyQuery describes a search of the stops around a position
+type NearbyQuery struct {
+	Lat float64
+	Lon float64
+	// Radius is the maximum distance to the position, in meters
+	Radius float64
+	// LineType restricts the search to the stops of lines of that transport type
+	LineType string
+	// LineSubmode restricts the search to the stops of lines of that transport submode
+	LineSubmode string
+	Limit       int
+}
+
+// Nearby returns the stops around the position of the query, closest first
+func Nearby(query NearbyQuery) ([]SearchResult, error) {
+	center := geo.Point{Lat: query.Lat, Lon: query.Lon}
+
+	hits, err := nearbyRows(center, query.Radius)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]referential.Stop, len(hits))
+	distances := make(map[string]float64, len(hits))
+	for index, hit := range hits {
+		rows[index] = hit.Item
+		distances[hit.Item.StopID] = hit.Distance
+	}
+
+	annotate := func(row referential.Stop, result *SearchResult) bool {
+		result.Distance = math.Round(distances[row.StopID])
+		return true
+	}
+	byDistance := func(a, b SearchResult) int {
+		return cmp.Or(cmp.Compare(a.Distance, b.Distance), cmp.Compare(a.Name, b.Name))
+	}
+
+	return groupRows(rows, query.LineType, query.LineSubmode, annotate, byDistance, query.Limit)
+}
+
+// nearbyRows returns the (line, stop) rows around the center from the offline referential, or from the API as a fallback
+func nearbyRows(center geo.Point, radius float64) ([]geo.Hit[referential.Stop], error) {
+	if index := referential.Current(); index != nil && len(index.Stops) > 0 {
+		return index.StopsWithin(center, radius), nil
+	}
+
+	// Prepare query parameters
+	params := url.Values{}
+	params.Add("where", odsql.WithinDistance("pointgeo", center.Lat, center.Lon, radius).String())
+
+	rows, totalCount, err := opendata.GetAllRecords[referential.Stop](stopRecordsEndpoint, params, env.IDFM_LISTING_CAP)
+	if err != nil {
+		return nil, err
+	}
+	opendata.Truncated("Stops nearby", len(rows), totalCount)
+
+	hits := make([]geo.Hit[referential.Stop], 0, len(rows))
+	for _, row := range rows {
+		position := geo.Point{Lat: float64(row.StopLat), Lon: float64(row.StopLon)}
+		hits = append(hits, geo.Hit[referential.Stop]{Item: row, Distance: geo.Distance(center, position)})
+	}
+	return hits, nil
+}
@@ -0,0 +1,34 @@
+package stop
+
+import "idfm/pkg/internal/utils"
+
+// Resolver resolves stops against the IDFM referential
+type Resolver struct{}
+
+func (Resolver) GetCachedStopIDsForDirection(lineId string, stopName string, direction string, platform string) (utils.StopId, bool) {
+	return GetCachedStopIDsForDirection(lineId, stopName, direction, platform)
+}
+
+func (Resolver) CacheStopIDForDirection(lineId string, stopName string, direction string, platform string, stopID utils.StopId) {
+	CacheStopIDForDirection(lineId, stopName, direction, platform, stopID)
+}
+
+func (Resolver) GetStopIDs(lineId string, stopName string) ([]utils.StopId, error) {
+	return GetStopIDs(lineId, stopName)
+}
+
+func (Resolver) GetStopIDsByName(stopName string, town string) ([]utils.StopId, error) {
+	return GetStopIDsByName(stopName, town)
+}
+
+func (Resolver) GetStopIDsOnLines(stopName string, lineIds []string) ([]utils.StopId, error) {
+	return GetStopIDsOnLines(stopName, lineIds)
+}
+
+func (Resolver) Search(query SearchQuery) ([]SearchResult, error) {
+	return Search(query)
+}
+
+func (Resolver) Nearby(query NearbyQuery) ([]SearchResult, error) {
+	return Nearby(query)
+}
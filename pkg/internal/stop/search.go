@@ -0,0 +1,227 @@
+package stop
+
+import (
+	"cmp"
+	"idfm/pkg/env"
+	"idfm/pkg/internal/fuzzy"
+	"idfm/pkg/internal/line"
+	"idfm/pkg/internal/odsql"
+	"idfm/pkg/internal/opendata"
+	"idfm/pkg/internal/referential"
+	"idfm/pkg/internal/utils"
+	"net/url"
+	"slices"
+)
+
+// maxSearchRows is the number of (line, stop) rows fetched from the API for a text search
+const maxSearchRows = 200
+
+// SearchQuery describes a stop search. At least Text or LineId must be set.
+type SearchQuery struct {
+	// Text is the beginning of the stop name, or the full name
+	Text string
+	// LineType restricts the search to the stops of lines of that transport type
+	LineType string
+	// LineSubmode restricts the search to the stops of lines of that transport submode
+	LineSubmode string
+	// LineId restricts the search to the stops of that line, such as "C01742"
+	LineId string
+	Limit  int
+}
+
+// SearchResult is a stop matching a search, along with the lines serving it
+type SearchResult struct {
+	Name   string         `json:"name"`
+	StopID string         `json:"stopId"`
+	Type   utils.StopType `json:"type"`
+	Town   string         `json:"town,omitempty"`
+	Lat    float64        `json:"lat,omitempty"`
+	Lon    float64        `json:"lon,omitempty"`
+	Lines  []ServingLine  `json:"lines"`
+	Score  float64        `json:"score,omitempty"`
+	// Distance is the distance to the searched position, in meters
+	Distance float64 `json:"distance,omitempty"`
+}
+
+// ServingLine is a line serving a stop
+type ServingLine struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"`
+	Operator string `json:"operator,omitempty"`
+}
+
+// Search returns the stops matching the query, best matches first
+func Search(query SearchQuery) ([]SearchResult, error) {
+	if query.Text == "" && query.LineId == "" {
+		return nil, &utils.RequestError{Message: "A stop name (q) or a line is required"}
+	}
+
+	rows, err := searchRows(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Score each distinct name once
+	scores := map[string]float64{}
+	annotate := func(row referential.Stop, result *SearchResult) bool {
+		if query.Text == "" {
+			return true
+		}
+		score, scored := scores[row.StopName]
+		if !scored {
+			score = fuzzy.Score(query.Text, row.StopName)
+			scores[row.StopName] = score
+		}
+		result.Score = score
+		return score >= fuzzy.MinScore
+	}
+	byScore := func(a, b SearchResult) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(a.Name, b.Name))
+	}
+
+	return groupRows(rows, query.LineType, query.LineSubmode, annotate, byScore, query.Limit)
+}
+
+// candidate is a stop being grouped, along with the lines serving it
+type candidate struct {
+	result  SearchResult
+	lineIds []string
+	// rows are the (line, stop) rows of the stop, by line ID
+	rows map[string]referential.Stop
+}
+
+// groupRows groups (line, stop) rows by stop, keeping the rows of lines of the given type and submode (any when empty).
+// annotate completes the result built from the first row of each stop, and tells whether the stop should be kept.
+// Stops are sorted with compare and cut to the limit (unbounded when zero) before their lines are resolved,
+// so that only the lines of the returned stops are looked up.
+func groupRows(rows []referential.Stop, lineType string, lineSubmode string, annotate func(row referential.Stop, result *SearchResult) bool, compare func(a, b SearchResult) int, limit int) ([]SearchResult, error) {
+	var candidates []*candidate
+	candidatesByStopId := map[string]*candidate{}
+	discarded := map[string]bool{}
+
+	for _, row := range rows {
+		if discarded[row.StopID] {
+			continue
+		}
+
+		stopCandidate, found := candidatesByStopId[row.StopID]
+		if !found {
+			stopId := toStopId(row.StopID)
+			stopCandidate = &candidate{
+				result: SearchResult{
+					Name:   row.StopName,
+					StopID: stopId.Id,
+					Type:   stopId.Type,
+					Town:   row.Town,
+					Lat:    float64(row.StopLat),
+					Lon:    float64(row.StopLon),
+					Lines:  []ServingLine{},
+				},
+				rows: map[string]referential.Stop{},
+			}
+			if !annotate(row, &stopCandidate.result) {
+				discarded[row.StopID] = true
+				continue
+			}
+			candidatesByStopId[row.StopID] = stopCandidate
+			candidates = append(candidates, stopCandidate)
+		}
+
+		if _, seen := stopCandidate.rows[row.LineID()]; !seen {
+			stopCandidate.rows[row.LineID()] = row
+			stopCandidate.lineIds = append(stopCandidate.lineIds, row.LineID())
+		}
+	}
+
+	slices.SortStableFunc(candidates, func(a, b *candidate) int { return compare(a.result, b.result) })
+
+	// Lines are resolved a batch of stops at a time, as the stops not served by lines of the mode are left out
+	filtered := lineType != "" || lineSubmode != ""
+	batchSize := len(candidates)
+	if limit > 0 {
+		batchSize = limit
+	}
+
+	results := []SearchResult{}
+	for start := 0; start < len(candidates) && (limit == 0 || len(results) < limit); start += batchSize {
+		batch := candidates[start:min(start+batchSize, len(candidates))]
+
+		var lineIds []string
+		seen := map[string]bool{}
+		for _, stopCandidate := range batch {
+			for _, lineId := range stopCandidate.lineIds {
+				if !seen[lineId] {
+					seen[lineId] = true
+					lineIds = append(lineIds, lineId)
+				}
+			}
+		}
+		lines, err := line.GetLinesByIds(lineIds)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, stopCandidate := range batch {
+			result := stopCandidate.result
+			for _, lineId := range stopCandidate.lineIds {
+				servingLine, known := lines[lineId]
+				if filtered && !servingLine.IsOfMode(lineType, lineSubmode) {
+					continue
+				}
+
+				row := stopCandidate.rows[lineId]
+				servedBy := ServingLine{ID: lineId, Name: row.ShortName, Operator: row.OperatorName}
+				if known {
+					servedBy = ServingLine{ID: servingLine.IDLine, Name: servingLine.NameLine, Type: servingLine.TransportMode, Operator: servingLine.OperatorName}
+				}
+				result.Lines = append(result.Lines, servedBy)
+			}
+
+			if filtered && len(result.Lines) == 0 {
+				continue
+			}
+			results = append(results, result)
+			if limit > 0 && len(results) == limit {
+				break
+			}
+		}
+	}
+	return results, nil
+}
+
+// searchRows returns the candidate (line, stop) rows from the offline referential when it is loaded, or from the API
+func searchRows(query SearchQuery) ([]referential.Stop, error) {
+	if index := referential.Current(); index != nil && len(index.Stops) > 0 {
+		if query.LineId != "" {
+			return index.StopsOfLine(query.LineId), nil
+		}
+		return index.Stops, nil
+	}
+
+	var filters []odsql.Expr
+	if query.LineId != "" {
+		filters = append(filters, odsql.Eq("id", "IDFM:"+query.LineId))
+	}
+	if query.Text != "" {
+		filters = append(filters, odsql.Search("stop_name", query.Text))
+	}
+
+	// Prepare query parameters
+	params := url.Values{}
+	params.Add("where", odsql.And(filters...).String())
+	params.Add("order_by", "stop_name")
+
+	// A text search is answered from its first rows rather than paging through every stop containing a common word
+	maxRows := env.IDFM_LISTING_CAP
+	if query.LineId == "" {
+		maxRows = min(maxRows, maxSearchRows)
+	}
+
+	rows, totalCount, err := opendata.GetAllRecords[referential.Stop](stopRecordsEndpoint, params, maxRows)
+	if err != nil {
+		return nil, err
+	}
+	opendata.Truncated("Stop search", len(rows), totalCount)
+	return rows, nil
+}
@@ -1,32 +1,32 @@
 package stop
 
 import (
-	"encoding/json"
 	"fmt"
+	"github.com/jellydator/ttlcache/v3"
 	"idfm/pkg/data"
+	"idfm/pkg/env"
+	"idfm/pkg/internal/fuzzy"
+	"idfm/pkg/internal/odsql"
+	"idfm/pkg/internal/opendata"
+	"idfm/pkg/internal/referential"
 	"idfm/pkg/internal/utils"
-	"io"
-	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 )
 
 const (
-	stopRecordsEndpoint = "https://data.iledefrance-mobilites.fr/api/explore/v2.1/catalog/datasets/arrets-lignes/records"
+	stopRecordsPath = "/api/explore/v2.1/catalog/datasets/arrets-lignes/records"
 )
 
-type stopIdsAPIResponse struct {
-	TotalCount int `json:"total_count"`
-	Results    []struct {
-		StopID string `json:"stop_id"`
-	} `json:"results"`
+var stopRecordsEndpoint = env.IDFM_OPENDATA_URL + stopRecordsPath
+
+type stopIdRecord struct {
+	StopID string `json:"stop_id"`
 }
 
-type stopNamesAPIResponse struct {
-	TotalCount int `json:"total_count"`
-	Results    []struct {
-		StopName string `json:"stop_name"`
-	} `json:"results"`
+type stopNameRecord struct {
+	StopName string `json:"stop_name"`
 }
 
 // GetCachedStopIDsForDirection retrieves stop IDs for the given stop and direction from the cache
@@ -46,100 +46,248 @@ func GetCachedStopIDsForDirection(lineId string, stopName string, direction stri
 	return utils.StopId{}, false
 }
 
+// CacheStopIDForDirection remembers the stop ID the visits of the line in that direction and at that platform were found at
+func CacheStopIDForDirection(lineId string, stopName string, direction string, platform string, stopID utils.StopId) {
+	stopCacheKey := data.StopCacheKey{
+		LineId:    lineId,
+		StopName:  stopName,
+		Direction: direction,
+		Platform:  platform,
+	}
+	data.StopIdForDirectionCache.Set(stopCacheKey, stopID, ttlcache.DefaultTTL)
+}
+
 // GetStopIDs retrieves stop IDs for the given stop from IDFM API
 func GetStopIDs(lineId string, stopName string) ([]utils.StopId, error) {
-	stopIdsResponse, err := requestStopIds(lineId, stopName)
+	rawStopIds, err := requestStopIds(lineId, stopName)
 	if err != nil {
 		return nil, err
 	}
 
-	if stopIdsResponse.TotalCount > 0 {
-		stopIDs := make([]utils.StopId, stopIdsResponse.TotalCount)
-
-		for index, result := range stopIdsResponse.Results {
-			stopId := result.StopID
-			numericPart := utils.OnlyNumberRegex.FindString(stopId)
-
-			if strings.Contains(stopId, "monomodalStopPlace") {
-				// monomodal means that we should query the area instead of the stop...
-				stopIDs[index] = utils.StopId{
-					Id:   numericPart,
-					Type: utils.Area,
-				}
-			} else {
-				stopIDs[index] = utils.StopId{
-					Id:   numericPart,
-					Type: utils.Point,
-				}
-			}
+	if len(rawStopIds) > 0 {
+		stopIDs := make([]utils.StopId, len(rawStopIds))
+
+		for index, stopId := range rawStopIds {
+			stopIDs[index] = toStopId(stopId)
 		}
 
 		return stopIDs, nil
 	} else {
-		// Help the user by providing stop names
-		allStopNamesResponse, err := requestAllStopNames(lineId)
+		// Look for the stop regardless of accents, case, punctuation and abbreviations
+		stopNames, truncated, err := requestAllStopNames(lineId)
 		if err != nil {
 			return nil, err
 		}
 
-		stopNames := make([]string, len(allStopNamesResponse.Results))
+		matches := fuzzy.Rank(stopName, stopNames)
+		if match, ok := fuzzy.Resolve(matches); ok && match.Name != stopName {
+			return GetStopIDs(lineId, match.Name)
+		}
 
-		for index, result := range allStopNamesResponse.Results {
-			stopNames[index] = result.StopName
+		if len(matches) > 0 {
+			return nil, &utils.CandidatesError{
+				Message:    fmt.Sprintf("Stop \"%s\" is ambiguous", stopName),
+				Candidates: matches,
+			}
 		}
 
-		marshal, err := json.Marshal(stopNames)
-		if err != nil {
-			return nil, err
+		// Help the user by providing stop names
+		message := fmt.Sprintf("Stop \"%s\" not found", stopName)
+		if truncated {
+			message += ". The candidates were cut at the listing cap"
+		}
+		return nil, &utils.CandidatesError{
+			Message:    message,
+			Candidates: stopNames,
 		}
-		return nil, &utils.RequestError{Message: fmt.Sprintf("Stop \"%s\" not found. Available stops: %s", stopName, marshal)}
 	}
 }
 
-func requestStopIds(lineId string, stopName string) (stopIdsAPIResponse, error) {
-	// Prepare query parameters
-	params := url.Values{}
-	params.Add("select", "stop_id")
-	params.Add("where", fmt.Sprintf("id=\"IDFM:%s\" AND stop_name=\"%s\"", lineId, stopName))
+// Place is a stop name in a town, given as a candidate when a name designates stops of several towns
+type Place struct {
+	Name string `json:"name"`
+	Town string `json:"town"`
+}
 
-	resp, err := http.Get(stopRecordsEndpoint + "?" + params.Encode())
+// GetStopIDsByName retrieves the stop IDs of the stop of that name, whatever the lines serving it.
+// The name is matched regardless of accents, case, punctuation and abbreviations.
+// When stops of several towns bear that name, the town must be given to pick one of them.
+func GetStopIDsByName(stopName string, town string) ([]utils.StopId, error) {
+	results, err := Search(SearchQuery{Text: stopName})
 	if err != nil {
-		return stopIdsAPIResponse{}, err
+		return nil, err
+	}
+
+	// Results are sorted by score, so are the distinct names
+	var matches []fuzzy.Match
+	for _, result := range results {
+		if !slices.ContainsFunc(matches, func(match fuzzy.Match) bool { return match.Name == result.Name }) {
+			matches = append(matches, fuzzy.Match{Name: result.Name, Score: result.Score})
+		}
+	}
+
+	match, ok := fuzzy.Resolve(matches)
+	if !ok {
+		if len(matches) > 0 {
+			return nil, &utils.CandidatesError{
+				Message:    fmt.Sprintf("Stop \"%s\" is ambiguous", stopName),
+				Candidates: matches,
+			}
+		}
+		return nil, &utils.RequestError{Message: fmt.Sprintf("Stop \"%s\" not found", stopName)}
+	}
+
+	var places []Place
+	stopIDsByTown := map[string][]utils.StopId{}
+	for _, result := range results {
+		if result.Name != match.Name {
+			continue
+		}
+		if _, found := stopIDsByTown[result.Town]; !found {
+			places = append(places, Place{Name: result.Name, Town: result.Town})
+		}
+		stopIDsByTown[result.Town] = append(stopIDsByTown[result.Town], utils.StopId{Id: result.StopID, Type: result.Type})
+	}
+
+	if town != "" {
+		places = slices.DeleteFunc(places, func(place Place) bool {
+			return fuzzy.Normalize(place.Town) != fuzzy.Normalize(town)
+		})
+		if len(places) == 0 {
+			return nil, &utils.RequestError{Message: fmt.Sprintf("Stop \"%s\" not found in %s", match.Name, town)}
+		}
+	}
+
+	if len(places) > 1 {
+		slices.SortFunc(places, func(a, b Place) int { return strings.Compare(a.Town, b.Town) })
+		return nil, &utils.CandidatesError{
+			Message:    fmt.Sprintf("Stop \"%s\" is found in several towns. Pick one with the town parameter", match.Name),
+			Candidates: places,
+		}
 	}
-	defer resp.Body.Close()
+	return stopIDsByTown[places[0].Town], nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// GetStopIDsOnLines retrieves the stop IDs of the stop of that name served by any of the lines.
+// The name is matched regardless of accents, case, punctuation and abbreviations.
+func GetStopIDsOnLines(stopName string, lineIds []string) ([]utils.StopId, error) {
+	results, err := Search(SearchQuery{Text: stopName})
 	if err != nil {
-		return stopIdsAPIResponse{}, err
+		return nil, err
+	}
+
+	results = slices.DeleteFunc(results, func(result SearchResult) bool {
+		return !slices.ContainsFunc(result.Lines, func(servingLine ServingLine) bool { return slices.Contains(lineIds, servingLine.ID) })
+	})
+
+	var matches []fuzzy.Match
+	for _, result := range results {
+		if !slices.ContainsFunc(matches, func(match fuzzy.Match) bool { return match.Name == result.Name }) {
+			matches = append(matches, fuzzy.Match{Name: result.Name, Score: result.Score})
+		}
+	}
+
+	match, ok := fuzzy.Resolve(matches)
+	if !ok {
+		if len(matches) > 0 {
+			return nil, &utils.CandidatesError{
+				Message:    fmt.Sprintf("Stop \"%s\" is ambiguous", stopName),
+				Candidates: matches,
+			}
+		}
+		return nil, &utils.RequestError{Message: fmt.Sprintf("Stop \"%s\" is not served by the lines of the stop", stopName)}
 	}
 
-	var apiResp stopIdsAPIResponse
-	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return stopIdsAPIResponse{}, err
+	var stopIDs []utils.StopId
+	for _, result := range results {
+		if result.Name == match.Name {
+			stopIDs = append(stopIDs, utils.StopId{Id: result.StopID, Type: result.Type})
+		}
+	}
+	return stopIDs, nil
+}
+
+// toStopId parses a stop ID of the referential, such as "IDFM:463158" or "IDFM:monomodalStopPlace:58566"
+func toStopId(stopId string) utils.StopId {
+	numericPart := utils.OnlyNumberRegex.FindString(stopId)
+
+	if strings.Contains(stopId, "monomodalStopPlace") {
+		// monomodal means that we should query the area instead of the stop...
+		return utils.StopId{
+			Id:   numericPart,
+			Type: utils.Area,
+		}
+	}
+	return utils.StopId{
+		Id:   numericPart,
+		Type: utils.Point,
 	}
-	return apiResp, nil
 }
 
-func requestAllStopNames(lineId string) (stopNamesAPIResponse, error) {
+// requestStopIds retrieves the stop IDs of the stop on the line from the offline referential when it is loaded,
+// or from the API
+func requestStopIds(lineId string, stopName string) ([]string, error) {
+	if index := referential.Current(); index != nil && len(index.Stops) > 0 {
+		// The offline referential is authoritative, so that misspelled stops are matched without the portal
+		var stopIds []string
+		for _, stop := range index.StopsOfLine(lineId) {
+			if stop.StopName == stopName {
+				stopIds = append(stopIds, stop.StopID)
+			}
+		}
+		return stopIds, nil
+	}
+
 	// Prepare query parameters
 	params := url.Values{}
-	params.Add("select", "stop_name")
-	params.Add("where", fmt.Sprintf("id=\"IDFM:%s\"", lineId))
-	params.Add("limit", "100")
-	resp, err := http.Get(stopRecordsEndpoint + "?" + params.Encode())
+	params.Add("select", "stop_id")
+	params.Add("where", odsql.And(odsql.Eq("id", "IDFM:"+lineId), odsql.Eq("stop_name", stopName)).String())
+
+	records, totalCount, err := opendata.GetAllRecords[stopIdRecord](stopRecordsEndpoint, params, env.IDFM_LISTING_CAP)
 	if err != nil {
-		return stopNamesAPIResponse{}, err
+		return nil, err
 	}
-	defer resp.Body.Close()
+	opendata.Truncated(fmt.Sprintf("Stop IDs of %s", stopName), len(records), totalCount)
 
-	body, err := io.ReadAll(resp.Body)
+	stopIds := make([]string, len(records))
+	for index, record := range records {
+		stopIds[index] = record.StopID
+	}
+	return stopIds, nil
+}
+
+// requestAllStopNames retrieves the distinct names of all stops of the line, up to the listing cap.
+// It also tells whether the names were cut at the listing cap.
+func requestAllStopNames(lineId string) ([]string, bool, error) {
+	if index := referential.Current(); index != nil && len(index.Stops) > 0 {
+		var stopNames []string
+		for _, stop := range index.StopsOfLine(lineId) {
+			if !slices.Contains(stopNames, stop.StopName) {
+				stopNames = append(stopNames, stop.StopName)
+			}
+		}
+		slices.Sort(stopNames)
+		return stopNames, false, nil
+	}
+
+	// Prepare query parameters
+	params := url.Values{}
+	params.Add("select", "stop_name")
+	params.Add("where", odsql.Eq("id", "IDFM:"+lineId).String())
+	params.Add("order_by", "stop_name")
+
+	records, totalCount, err := opendata.GetAllRecords[stopNameRecord](stopRecordsEndpoint, params, env.IDFM_LISTING_CAP)
 	if err != nil {
-		return stopNamesAPIResponse{}, err
+		return nil, false, err
 	}
+	truncated := opendata.Truncated(fmt.Sprintf("Names of the stops of %s", lineId), len(records), totalCount)
 
-	var apiResp stopNamesAPIResponse
-	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return apiResp, err
+	stopNames := make([]string, 0, len(records))
+	for _, record := range records {
+		if !slices.Contains(stopNames, record.StopName) {
+			stopNames = append(stopNames, record.StopName)
+		}
 	}
-	return apiResp, nil
+
+	return stopNames, truncated, nil
 }
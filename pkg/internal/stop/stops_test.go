@@ -0,0 +1,83 @@
+package stop
+
+import (
+	"errors"
+	"idfm/pkg/env"
+	"idfm/pkg/internal/referential"
+	"idfm/pkg/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// downPortal points the stop records at an opendata portal failing every request, and counts the requests
+func downPortal(t *testing.T) *atomic.Int32 {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	endpoint := stopRecordsEndpoint
+	stopRecordsEndpoint = server.URL + stopRecordsPath
+	t.Cleanup(func() { stopRecordsEndpoint = endpoint })
+	return &requests
+}
+
+// loadStops loads an offline referential made of the given arrets-lignes CSV export
+func loadStops(t *testing.T, stops string) {
+	path := filepath.Join(t.TempDir(), "stops.csv")
+	if err := os.WriteFile(path, []byte(stops), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env.IDFM_STOPS_DATASET_FILE = path
+	t.Cleanup(func() {
+		env.IDFM_STOPS_DATASET_FILE = ""
+		_ = referential.Load()
+	})
+	if err := referential.Load(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetStopIDsOffline(t *testing.T) {
+	requests := downPortal(t)
+	loadStops(t, "id;route_long_name;stop_id;stop_name;stop_lon;stop_lat;operatorname;nom_commune\n"+
+		"IDFM:C01742;A;IDFM:monomodalStopPlace:473921;Auber;2.329;48.872;RATP;Paris\n"+
+		"IDFM:C01742;A;IDFM:monomodalStopPlace:474151;Châtelet-Les Halles;2.347;48.861;RATP;Paris\n"+
+		"IDFM:C01742;A;IDFM:monomodalStopPlace:470549;Charles de Gaulle-Etoile;2.295;48.874;RATP;Paris\n")
+
+	stopIDs, err := GetStopIDs("C01742", "chatelet les halles")
+	if err != nil || len(stopIDs) != 1 || stopIDs[0].Id != "474151" {
+		t.Errorf("GetStopIDs(chatelet les halles) = %v, %v, want 474151", stopIDs, err)
+	}
+
+	_, err = GetStopIDs("C01742", "Nation")
+	var candidatesErr *utils.CandidatesError
+	if !errors.As(err, &candidatesErr) {
+		t.Errorf("GetStopIDs(Nation) error = %v, want the stops of the line as candidates", err)
+	}
+
+	if _, err := Search(SearchQuery{Text: "Nation", LineId: "C01742"}); err != nil {
+		t.Errorf("Search(Nation) error = %v, want no result", err)
+	}
+
+	if requests.Load() != 0 {
+		t.Errorf("portal requested %d times, want none while the offline referential is loaded", requests.Load())
+	}
+}
+
+func TestGetStopIDsWithoutReferential(t *testing.T) {
+	requests := downPortal(t)
+
+	if _, err := GetStopIDs("C01742", "Auber"); err == nil {
+		t.Error("got no error from a down portal")
+	}
+	if requests.Load() == 0 {
+		t.Error("portal not requested without an offline referential")
+	}
+}
@@ -0,0 +1,164 @@
+package time
+
+import (
+	"idfm/pkg/internal/direction"
+	"idfm/pkg/internal/siri"
+	"idfm/pkg/internal/utils"
+	"time"
+)
+
+// Departure is a visit of a vehicle at a stop, as served by the v2 API
+type Departure struct {
+	Line    DepartureLine    `json:"line"`
+	Journey DepartureJourney `json:"journey"`
+	// Direction is "A" or "R", omitted when it cannot be told
+	Direction   string           `json:"direction,omitempty"`
+	Destination DeparturePlace   `json:"destination"`
+	Stop        DepartureStop    `json:"stop"`
+	Times       DepartureTimes   `json:"times"`
+	Status      DepartureStatus  `json:"status"`
+	Remaining   DepartureCounter `json:"remaining"`
+	// Delay is how late the vehicle is expected compared to its aimed time, in seconds (negative when early),
+	// omitted when PRIM does not provide both times
+	Delay *int `json:"delay,omitempty"`
+}
+
+// DepartureLine identifies the line of a departure
+type DepartureLine struct {
+	// ID is the IDFM line ID, such as "C01742"
+	ID string `json:"id"`
+	// Ref is the SIRI line ref, such as "STIF:Line::C01742:"
+	Ref string `json:"ref"`
+}
+
+// DepartureJourney identifies the vehicle journey of a departure
+type DepartureJourney struct {
+	// Ref is the SIRI dated vehicle journey ref, stable across the stops of the journey
+	Ref string `json:"ref,omitempty"`
+	// MissionCode is the name of the journey, such as "TOTO" for RER and Transilien missions
+	MissionCode  string   `json:"missionCode,omitempty"`
+	TrainNumbers []string `json:"trainNumbers,omitempty"`
+	// Notes are the journey notes, such as the mission code displayed on trains
+	Notes []string `json:"notes,omitempty"`
+}
+
+// DeparturePlace is the destination of a departure
+type DeparturePlace struct {
+	Ref  string `json:"ref,omitempty"`
+	Name string `json:"name"`
+	// Display is the destination as displayed on the vehicle, when it differs from its name
+	Display string `json:"display,omitempty"`
+}
+
+// DepartureStop is the stop point the departure is monitored at
+type DepartureStop struct {
+	// MonitoringRef is the SIRI monitoring ref, such as "STIF:StopPoint:Q:473921:"
+	MonitoringRef string `json:"monitoringRef"`
+	Name          string `json:"name,omitempty"`
+	Platform      string `json:"platform,omitempty"`
+	// QuayRef is the quay the vehicle is expected at
+	QuayRef string `json:"quayRef,omitempty"`
+}
+
+// DepartureTimes are the times of a departure in the Europe/Paris time zone, omitted when PRIM does not provide them
+type DepartureTimes struct {
+	AimedArrival      *time.Time `json:"aimedArrival,omitempty"`
+	ExpectedArrival   *time.Time `json:"expectedArrival,omitempty"`
+	AimedDeparture    *time.Time `json:"aimedDeparture,omitempty"`
+	ExpectedDeparture *time.Time `json:"expectedDeparture,omitempty"`
+}
+
+// DepartureStatus is the status of a departure as reported by PRIM, such as "onTime" or "delayed"
+type DepartureStatus struct {
+	Arrival   string `json:"arrival,omitempty"`
+	Departure string `json:"departure,omitempty"`
+	// AtStop tells whether the vehicle is at the stop
+	AtStop bool `json:"atStop"`
+}
+
+// DepartureCounter is the time remaining until the expected departure (or arrival at a terminus)
+type DepartureCounter struct {
+	Minutes int `json:"minutes"`
+	Seconds int `json:"seconds"`
+}
+
+// ToDepartures converts visits to departures
+func ToDepartures(visits []siri.MonitoredStopVisit) []Departure {
+	departures := make([]Departure, len(visits))
+	for index, visit := range visits {
+		departures[index] = toDeparture(visit)
+	}
+	return departures
+}
+
+// toDeparture converts a visit to a departure
+func toDeparture(entry siri.MonitoredStopVisit) Departure {
+	journey := entry.MonitoredVehicleJourney
+	call := journey.MonitoredCall
+	remainingSeconds := max(0, int(time.Until(expectedTime(entry)).Seconds()))
+
+	destination := DeparturePlace{
+		Ref:  journey.DestinationRef.Value,
+		Name: firstValue(journey.DestinationName),
+	}
+	if display := firstValue(call.DestinationDisplay); display != destination.Name {
+		destination.Display = display
+	}
+
+	return Departure{
+		Line: DepartureLine{
+			ID:  lineIdOf(entry),
+			Ref: journey.LineRef.Value,
+		},
+		Journey: DepartureJourney{
+			Ref:          journey.FramedVehicleJourneyRef.DatedVehicleJourneyRef,
+			MissionCode:  firstValue(journey.VehicleJourneyName),
+			TrainNumbers: values(journey.TrainNumbers.TrainNumberRef),
+			Notes:        values(journey.JourneyNote),
+		},
+		Direction:   direction.Resolve(entry),
+		Destination: destination,
+		Stop: DepartureStop{
+			MonitoringRef: entry.MonitoringRef.Value,
+			Name:          firstValue(call.StopPointName),
+			Platform:      call.ArrivalPlatformName.Value,
+			QuayRef:       call.DepartureStopAssignment.ExpectedQuayRef.Value,
+		},
+		Times: DepartureTimes{
+			AimedArrival:      parisTime(call.AimedArrivalTime),
+			ExpectedArrival:   parisTime(call.ExpectedArrivalTime),
+			AimedDeparture:    parisTime(call.AimedDepartureTime),
+			ExpectedDeparture: parisTime(call.ExpectedDepartureTime),
+		},
+		Status: DepartureStatus{
+			Arrival:   call.ArrivalStatus,
+			Departure: call.DepartureStatus,
+			AtStop:    call.VehicleAtStop,
+		},
+		Remaining: DepartureCounter{
+			Minutes: remainingSeconds / 60,
+			Seconds: remainingSeconds,
+		},
+		Delay: delay(call),
+	}
+}
+
+// parisTime returns the time in the Europe/Paris time zone, or nil for the zero time
+func parisTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	paris := t.In(utils.Paris)
+	return &paris
+}
+
+// values returns the non-empty values
+func values(wrappers []siri.ValueWrapper) []string {
+	var values []string
+	for _, wrapper := range wrappers {
+		if wrapper.Value != "" {
+			values = append(values, wrapper.Value)
+		}
+	}
+	return values
+}
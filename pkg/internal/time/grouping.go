@@ -0,0 +1,94 @@
+package time
+
+import (
+	"cmp"
+	"idfm/pkg/internal/direction"
+	"idfm/pkg/internal/line"
+	"idfm/pkg/internal/siri"
+	"slices"
+)
+
+// LineGroup are the next departures of a line in one direction
+type LineGroup[T any] struct {
+	Line       line.Details `json:"line"`
+	Direction  string       `json:"direction,omitempty"`
+	Departures []T          `json:"departures"`
+}
+
+// LineDepartures are the next departures of a line in one direction, as served by the v1 API
+type LineDepartures = LineGroup[Result]
+
+// LineIds returns the distinct IDs of the lines of the visits
+func LineIds(entries []siri.MonitoredStopVisit) []string {
+	var lineIds []string
+	for _, entry := range entries {
+		if lineId := lineIdOf(entry); !slices.Contains(lineIds, lineId) {
+			lineIds = append(lineIds, lineId)
+		}
+	}
+	return lineIds
+}
+
+// GroupByLine groups visits by line and direction, keeping the next perGroup departures of each, soonest first.
+// The same journey seen from several monitoring refs only counts once.
+func GroupByLine(entries []siri.MonitoredStopVisit, lines map[string]line.Details, perGroup int) []LineDepartures {
+	return groupByLine(entries, lines, perGroup, toResult)
+}
+
+// GroupDeparturesByLine groups visits by line and direction like GroupByLine, as departures of the v2 API
+func GroupDeparturesByLine(entries []siri.MonitoredStopVisit, lines map[string]line.Details, perGroup int) []LineGroup[Departure] {
+	return groupByLine(entries, lines, perGroup, toDeparture)
+}
+
+func groupByLine[T any](entries []siri.MonitoredStopVisit, lines map[string]line.Details, perGroup int, convert func(entry siri.MonitoredStopVisit) T) []LineGroup[T] {
+	sorted := slices.Clone(entries)
+	sortByExpectedTime(sorted)
+
+	type groupKey struct {
+		lineId    string
+		direction string
+	}
+	var groups []*LineGroup[T]
+	groupsByKey := map[groupKey]*LineGroup[T]{}
+	seen := map[string]bool{}
+
+	for _, entry := range sorted {
+		if journey := entry.MonitoredVehicleJourney.FramedVehicleJourneyRef.DatedVehicleJourneyRef; journey != "" {
+			if seen[journey] {
+				continue
+			}
+			seen[journey] = true
+		}
+
+		key := groupKey{lineId: lineIdOf(entry), direction: direction.Resolve(entry)}
+		group, found := groupsByKey[key]
+		if !found {
+			details, known := lines[key.lineId]
+			if !known {
+				details = line.Details{ID: key.lineId}
+			}
+			group = &LineGroup[T]{Line: details, Direction: key.direction, Departures: []T{}}
+			groupsByKey[key] = group
+			groups = append(groups, group)
+		}
+
+		if perGroup <= 0 || len(group.Departures) < perGroup {
+			group.Departures = append(group.Departures, convert(entry))
+		}
+	}
+
+	slices.SortStableFunc(groups, func(a, b *LineGroup[T]) int {
+		return cmp.Or(
+			cmp.Compare(a.Line.Type, b.Line.Type),
+			cmp.Compare(a.Line.Name, b.Line.Name),
+			cmp.Compare(a.Line.ID, b.Line.ID),
+			cmp.Compare(a.Direction, b.Direction),
+		)
+	})
+
+	lineDepartures := make([]LineGroup[T], len(groups))
+	for index, group := range groups {
+		lineDepartures[index] = *group
+	}
+	return lineDepartures
+}
@@ -2,10 +2,11 @@ package time
 
 import (
 	"fmt"
-	"github.com/jellydator/ttlcache/v3"
-	"idfm/pkg/data"
+	"idfm/pkg/internal/direction"
+	"idfm/pkg/internal/siri"
 	"idfm/pkg/internal/utils"
 	"math"
+	"slices"
 	"strings"
 	"time"
 )
@@ -16,117 +17,215 @@ type Result struct {
 	Time     string `json:"time"`
 	Status   string `json:"status"`
 	Platform string `json:"platform,omitempty"`
+	// Line is the IDFM line ID, only set when the results are not restricted to a line
+	Line string `json:"line,omitempty"`
+
+	// Times are ISO-8601 in the Europe/Paris time zone, and omitted when PRIM does not provide them
+	AimedArrival      string `json:"aimedArrival,omitempty"`
+	ExpectedArrival   string `json:"expectedArrival,omitempty"`
+	AimedDeparture    string `json:"aimedDeparture,omitempty"`
+	ExpectedDeparture string `json:"expectedDeparture,omitempty"`
+	// Minutes and Seconds are the time remaining until the expected departure (or arrival at a terminus)
+	Minutes int `json:"minutes"`
+	Seconds int `json:"seconds"`
+	// Delay is how late the vehicle is expected compared to its aimed time, in seconds (negative when early),
+	// omitted when PRIM does not provide both times
+	Delay *int `json:"delay,omitempty"`
 }
 
-// FindResults processes entries and requests to find matching results
-func FindResults(entries []MonitoredStopVisit, lineId string, stopIds []utils.StopId, stopName string, destination string, platform string) []Result {
-	results := make([]Result, 0)
+// FindVisits returns the visits of the line at the requested stop IDs, in the direction and at the platform when given,
+// soonest first and as selected
+func FindVisits(entries []siri.MonitoredStopVisit, lineId string, stopIds []utils.StopId, destination string, platform string, selection Selection) []siri.MonitoredStopVisit {
+	visits := make([]siri.MonitoredStopVisit, 0)
 
 	for _, requestedStopId := range stopIds {
 		for _, entry := range entries {
-			// Check LineRef
-			lineRefValue := entry.MonitoredVehicleJourney.LineRef.Value
-			if lineRefValue != fmt.Sprintf("STIF:Line::%s:", lineId) {
+			if !matches(entry, lineId, destination, platform) {
 				continue
 			}
 
-			// Check Platform
-			if platform != "" && platform != entry.MonitoredVehicleJourney.MonitoredCall.ArrivalPlatformName.Value {
+			// there's a bug where the returned stop id is different from the requested one...
+			// workaround this bug by assuming it is the same if the number of requested stops is 1
+			// example: /api/idfm/timings/rail/A/Auber?direction=A
+			if len(stopIds) > 1 && !isVisitAt(entry, requestedStopId) {
 				continue
 			}
 
-			// Check Direction
-			dirRefValue := entry.MonitoredVehicleJourney.DirectionRef.Value
-			var dir string
-
-			// Unambiguous explicit suffixes take highest priority
-			if strings.HasSuffix(dirRefValue, ":A") {
-				dir = "A"
-			} else if strings.HasSuffix(dirRefValue, ":R") {
-				dir = "R"
-			} else {
-				// For rail services (RER/Transilien), DirectionRef is always "Aller" for every
-				// train regardless of travel direction. Use mission number parity instead:
-				//   even last digit → ascending / eastbound / toward Paris  (A)
-				//   odd  last digit → descending / westbound / away from Paris (R)
-				if names := entry.MonitoredVehicleJourney.VehicleJourneyName; len(names) > 0 {
-					if name := names[0].Value; len(name) > 0 {
-						if last := name[len(name)-1]; last >= '0' && last <= '9' {
-							if (last-'0')%2 == 0 {
-								dir = "A"
-							} else {
-								dir = "R"
-							}
-						}
-					}
-				}
-				// Fall back to text-based direction when parity is not applicable (buses, tram)
-				if dir == "" {
-					switch dirRefValue {
-					case "Aller":
-						dir = "A"
-					case "Retour":
-						dir = "R"
-					default:
-						if len(entry.MonitoredVehicleJourney.DirectionName) > 0 {
-							switch entry.MonitoredVehicleJourney.DirectionName[0].Value {
-							case "Aller":
-								dir = "A"
-							case "Retour":
-								dir = "R"
-							}
-						}
-					}
-				}
-			}
+			visits = append(visits, entry)
+		}
+	}
 
-			if destination != "" && destination != dir {
-				continue
-			}
+	// Visits of several stop IDs are interleaved
+	sortByExpectedTime(visits)
 
-			// Check stop ID
-			stopIDFull := entry.MonitoringRef.Value
-			stopID := utils.OnlyNumberRegex.FindString(stopIDFull)
+	return selection.Apply(visits)
+}
 
-			// there's a bug where the returned stop id is different from the requested one...
-			// workaround this bug by assuming it is the same if the number of requested stops is 1
-			// example: /api/idfm/timings/rail/A/Auber?direction=A
-			if len(stopIds) > 1 {
-				if stopID != requestedStopId.Id {
-					continue
-				}
-			}
+// VisitedStopId returns the requested stop ID the first of the visits was found at
+func VisitedStopId(visits []siri.MonitoredStopVisit, stopIds []utils.StopId) (utils.StopId, bool) {
+	if len(visits) == 0 {
+		return utils.StopId{}, false
+	}
+	// The returned stop ID may differ from the only requested one, see FindVisits
+	if len(stopIds) == 1 {
+		return stopIds[0], true
+	}
 
-			// Calculate remaining time
-			var remainingTime string
-			if entry.MonitoredVehicleJourney.MonitoredCall.VehicleAtStop {
-				remainingTime = "onStop"
-			} else {
-				upcoming := entry.MonitoredVehicleJourney.MonitoredCall.ExpectedDepartureTime
-				remaining := int(math.Max(0, math.Floor(upcoming.Sub(time.Now()).Minutes())))
-				remainingTime = fmt.Sprintf("%d mn", remaining)
-			}
+	for _, stopId := range stopIds {
+		if isVisitAt(visits[0], stopId) {
+			return stopId, true
+		}
+	}
+	return utils.StopId{}, false
+}
 
-			// Store result
-			results = append(results, Result{
-				Dest:     entry.MonitoredVehicleJourney.DestinationName[0].Value,
-				Time:     remainingTime,
-				Status:   entry.MonitoredVehicleJourney.MonitoredCall.DepartureStatus,
-				Platform: entry.MonitoredVehicleJourney.MonitoredCall.ArrivalPlatformName.Value,
-			})
-
-			// Update cache
-			if destination != "" || platform != "" {
-				stopCacheKey := data.StopCacheKey{
-					LineId:    lineId,
-					StopName:  stopName,
-					Direction: destination,
-					Platform:  platform,
-				}
-				data.StopIdForDirectionCache.Set(stopCacheKey, requestedStopId, ttlcache.DefaultTTL)
-			}
+// isVisitAt tells whether the visit was monitored at the stop ID
+func isVisitAt(entry siri.MonitoredStopVisit, stopId utils.StopId) bool {
+	return utils.OnlyNumberRegex.FindString(entry.MonitoringRef.Value) == stopId.Id
+}
+
+// FilterResults returns the results of the line in the direction and at the platform, soonest first and as selected.
+// Empty filters match every visit; without a line, each result tells its line.
+func FilterResults(entries []siri.MonitoredStopVisit, lineId string, destination string, platform string, selection Selection) []Result {
+	visits := FilterVisits(entries, lineId, destination, platform, selection)
+
+	results := ToResults(visits)
+	if lineId == "" {
+		for index, visit := range visits {
+			results[index].Line = lineIdOf(visit)
+		}
+	}
+	return results
+}
+
+// FilterVisits returns the visits of the line in the direction and at the platform, soonest first and as selected.
+// Empty filters match every visit.
+func FilterVisits(entries []siri.MonitoredStopVisit, lineId string, destination string, platform string, selection Selection) []siri.MonitoredStopVisit {
+	sorted := slices.Clone(entries)
+	sortByExpectedTime(sorted)
+
+	visits := make([]siri.MonitoredStopVisit, 0)
+	for _, entry := range sorted {
+		entryLineId := lineId
+		if entryLineId == "" {
+			entryLineId = lineIdOf(entry)
+		}
+		if matches(entry, entryLineId, destination, platform) {
+			visits = append(visits, entry)
 		}
 	}
+	return selection.Apply(visits)
+}
+
+// sortByExpectedTime sorts visits soonest first
+func sortByExpectedTime(visits []siri.MonitoredStopVisit) {
+	slices.SortStableFunc(visits, func(a, b siri.MonitoredStopVisit) int {
+		return expectedTime(a).Compare(expectedTime(b))
+	})
+}
 
+// ToResults converts visits to results
+func ToResults(visits []siri.MonitoredStopVisit) []Result {
+	results := make([]Result, len(visits))
+	for index, visit := range visits {
+		results[index] = toResult(visit)
+	}
 	return results
 }
+
+// matches tells whether a visit is one of the line, in the direction and at the platform, when they are given
+func matches(entry siri.MonitoredStopVisit, lineId string, destination string, platform string) bool {
+	// Check LineRef
+	lineRefValue := entry.MonitoredVehicleJourney.LineRef.Value
+	if lineRefValue != fmt.Sprintf("STIF:Line::%s:", lineId) {
+		return false
+	}
+
+	// Check Platform
+	if platform != "" && platform != entry.MonitoredVehicleJourney.MonitoredCall.ArrivalPlatformName.Value {
+		return false
+	}
+
+	// Check Direction
+	if destination != "" && destination != direction.Resolve(entry) {
+		return false
+	}
+	return true
+}
+
+// lineIdOf returns the ID of the line of a visit, such as "C01742" for "STIF:Line::C01742:"
+func lineIdOf(entry siri.MonitoredStopVisit) string {
+	lineRef := strings.TrimPrefix(entry.MonitoredVehicleJourney.LineRef.Value, "STIF:Line::")
+	return strings.TrimSuffix(lineRef, ":")
+}
+
+// toResult converts a visit to a result
+func toResult(entry siri.MonitoredStopVisit) Result {
+	// Calculate remaining time
+	var remainingTime string
+	if entry.MonitoredVehicleJourney.MonitoredCall.VehicleAtStop {
+		remainingTime = "onStop"
+	} else {
+		// At a terminus, only the arrival time is given
+		upcoming := expectedTime(entry)
+		remaining := int(math.Max(0, math.Floor(upcoming.Sub(time.Now()).Minutes())))
+		remainingTime = fmt.Sprintf("%d mn", remaining)
+	}
+
+	call := entry.MonitoredVehicleJourney.MonitoredCall
+	remainingSeconds := max(0, int(time.Until(expectedTime(entry)).Seconds()))
+
+	return Result{
+		Dest:              firstValue(entry.MonitoredVehicleJourney.DestinationName),
+		Time:              remainingTime,
+		Status:            call.DepartureStatus,
+		Platform:          call.ArrivalPlatformName.Value,
+		AimedArrival:      isoTime(call.AimedArrivalTime),
+		ExpectedArrival:   isoTime(call.ExpectedArrivalTime),
+		AimedDeparture:    isoTime(call.AimedDepartureTime),
+		ExpectedDeparture: isoTime(call.ExpectedDepartureTime),
+		Minutes:           remainingSeconds / 60,
+		Seconds:           remainingSeconds,
+		Delay:             delay(call),
+	}
+}
+
+// isoTime formats a time in the Europe/Paris time zone, or returns "" for the zero time
+func isoTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(utils.Paris).Format(time.RFC3339)
+}
+
+// delay returns how late the departure is expected, or the arrival at a terminus, in seconds.
+// It returns nil when the aimed or expected time is missing, rather than telling the vehicle is on time.
+func delay(call siri.MonitoredCall) *int {
+	var seconds int
+	switch {
+	case !call.ExpectedDepartureTime.IsZero() && !call.AimedDepartureTime.IsZero():
+		seconds = int(call.ExpectedDepartureTime.Sub(call.AimedDepartureTime).Seconds())
+	case !call.ExpectedArrivalTime.IsZero() && !call.AimedArrivalTime.IsZero():
+		seconds = int(call.ExpectedArrivalTime.Sub(call.AimedArrivalTime).Seconds())
+	default:
+		return nil
+	}
+	return &seconds
+}
+
+// firstValue returns the first of the values, or "" when there is none
+func firstValue(values []siri.ValueWrapper) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0].Value
+}
+
+// expectedTime returns the expected departure time of a visit, or its expected arrival time at a terminus
+func expectedTime(entry siri.MonitoredStopVisit) time.Time {
+	call := entry.MonitoredVehicleJourney.MonitoredCall
+	if !call.ExpectedDepartureTime.IsZero() {
+		return call.ExpectedDepartureTime
+	}
+	return call.ExpectedArrivalTime
+}
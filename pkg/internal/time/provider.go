@@ -0,0 +1,13 @@
+package time
+
+import (
+	"context"
+	"idfm/pkg/internal/utils"
+)
+
+// Provider retrieves timings from PRIM stop monitoring
+type Provider struct{}
+
+func (Provider) GetAllTimings(ctx context.Context, stopIDs []utils.StopId) (Timings, error) {
+	return GetAllTimings(ctx, stopIDs)
+}
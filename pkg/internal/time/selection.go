@@ -0,0 +1,86 @@
+package time
+
+import (
+	"idfm/pkg/internal/fuzzy"
+	"idfm/pkg/internal/siri"
+	"idfm/pkg/internal/utils"
+	"time"
+)
+
+// Selection selects the next departures to return among visits
+type Selection struct {
+	// From is the number of minutes from now departures start to be kept
+	From int
+	// To is the number of minutes from now departures stop being kept, unbounded when nil
+	To *int
+	// Limit is the maximum number of departures, unbounded when zero
+	Limit int
+	// Destination keeps the departures heading to that destination, given by name or by stop ref
+	Destination string
+	// Via keeps the departures of the journeys calling at another stop afterwards, when set with JourneyTimes
+	Via map[string]time.Time
+}
+
+// JourneyTimes returns the expected time of each journey of the visits, for the Via selection
+func JourneyTimes(visits []siri.MonitoredStopVisit) map[string]time.Time {
+	journeyTimes := make(map[string]time.Time, len(visits))
+	for _, visit := range visits {
+		if journey := visit.MonitoredVehicleJourney.FramedVehicleJourneyRef.DatedVehicleJourneyRef; journey != "" {
+			journeyTimes[journey] = expectedTime(visit)
+		}
+	}
+	return journeyTimes
+}
+
+// Apply keeps the selected visits, up to the limit, in their order
+func (s Selection) Apply(visits []siri.MonitoredStopVisit) []siri.MonitoredStopVisit {
+	now := time.Now()
+
+	kept := make([]siri.MonitoredStopVisit, 0, len(visits))
+	for _, visit := range visits {
+		if s.Limit > 0 && len(kept) == s.Limit {
+			break
+		}
+
+		expected := expectedTime(visit)
+		// Vehicles at the stop are kept until they leave, even when expected a few seconds ago
+		if s.From > 0 && expected.Before(now.Add(time.Duration(s.From)*time.Minute)) {
+			continue
+		}
+		if s.To != nil && expected.After(now.Add(time.Duration(*s.To)*time.Minute)) {
+			continue
+		}
+		if s.Destination != "" && !headsTo(visit, s.Destination) {
+			continue
+		}
+		if s.Via != nil && !callsAfter(visit, s.Via) {
+			continue
+		}
+		kept = append(kept, visit)
+	}
+	return kept
+}
+
+// headsTo tells whether a visit heads to the destination, given by stop ref, numeric stop ID or name
+func headsTo(visit siri.MonitoredStopVisit, destination string) bool {
+	journey := visit.MonitoredVehicleJourney
+
+	ref := journey.DestinationRef.Value
+	if ref != "" && (ref == destination || utils.OnlyNumberRegex.FindString(ref) == destination) {
+		return true
+	}
+
+	for _, name := range []string{firstValue(journey.DestinationName), firstValue(journey.MonitoredCall.DestinationDisplay)} {
+		if name != "" && fuzzy.Score(destination, name) >= fuzzy.MinScore {
+			return true
+		}
+	}
+	return false
+}
+
+// callsAfter tells whether the journey of a visit is expected at the other stop later on
+func callsAfter(visit siri.MonitoredStopVisit, journeyTimes map[string]time.Time) bool {
+	journey := visit.MonitoredVehicleJourney.FramedVehicleJourneyRef.DatedVehicleJourneyRef
+	viaTime, ok := journeyTimes[journey]
+	return journey != "" && ok && viaTime.After(expectedTime(visit))
+}
@@ -1,73 +1,299 @@
 package time
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/jellydator/ttlcache/v3"
+	"golang.org/x/sync/singleflight"
+	"idfm/pkg/data"
 	"idfm/pkg/env"
+	"idfm/pkg/internal/apikey"
+	"idfm/pkg/internal/quota"
+	"idfm/pkg/internal/siri"
+	"idfm/pkg/internal/upstream"
 	"idfm/pkg/internal/utils"
+	"net"
 	"net/http"
 	"net/url"
-	"time"
+	"strings"
+	"sync"
 )
 
 const (
-	stopMonitoringEndpoint = "https://prim.iledefrance-mobilites.fr/marketplace/stop-monitoring"
+	stopMonitoringPath = "/marketplace/stop-monitoring"
 )
 
+var stopMonitoringEndpoint = env.IDFM_PRIM_URL + stopMonitoringPath
+
+// stopMonitoringGroup collapses concurrent stop monitoring requests for the same monitoring ref
+var stopMonitoringGroup singleflight.Group
+
+func init() {
+	// A key rejected by PRIM is switched for the next one at once, rather than retried and spending more of its quota
+	upstream.Prim.HandBack(http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests)
+
+	// Every attempt, retries included, counts against the daily quota of the key.
+	// Keys rejected by PRIM are not counted, so that made-up client keys are not tracked.
+	upstream.Prim.OnAttempt(func(req *http.Request, resp *http.Response) {
+		if resp != nil && resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+			quota.Record(req.Header.Get("apiKey"))
+		}
+	})
+}
+
 // StopMonitoringAPIResponse represents the structure of the API response
 type StopMonitoringAPIResponse struct {
-	Siri Siri `json:"Siri"`
+	Siri siri.Siri `json:"Siri"`
 }
 
-// GetAllTimings retrieves all timings for the given stop IDs with typed data
-func GetAllTimings(stopIDs []utils.StopId) ([]MonitoredStopVisit, error) {
-	var allTimings []MonitoredStopVisit
+// FailedRef describes a monitoring ref whose timings could not be retrieved
+type FailedRef struct {
+	MonitoringRef string `json:"monitoringRef"`
+	// Reason is either "timeout", "quota", "error", or "skipped" when too many stops were requested
+	Reason string `json:"reason"`
+}
 
-	for _, stopID := range stopIDs {
-		if data, err := requestInfo(stopID); err == nil {
-			allTimings = append(allTimings, data...)
-		} else {
-			return nil, err
+// Skipped reports stop IDs that were not requested, to keep the number of upstream calls bounded
+func Skipped(stopIDs []utils.StopId) []FailedRef {
+	skipped := make([]FailedRef, len(stopIDs))
+	for index, stopID := range stopIDs {
+		skipped[index] = FailedRef{MonitoringRef: monitoringRefOrId(stopID), Reason: "skipped"}
+	}
+	return skipped
+}
+
+// ParseMonitoringRef parses a stop given by its monitoring ref, such as "STIF:StopArea:SP:58566:" or "STIF:StopPoint:Q:473921:"
+func ParseMonitoringRef(value string) (utils.StopId, bool) {
+	for stopType, prefix := range monitoringRefPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			stopID, err := ParseStopId(stopType, value)
+			return stopID, err == nil
 		}
 	}
+	return utils.StopId{}, false
+}
 
-	return allTimings, nil
+// Timings holds the visits retrieved for a set of stop IDs, along with the monitoring refs that failed
+type Timings struct {
+	Visits []siri.MonitoredStopVisit
+	Failed []FailedRef
 }
 
-// requestInfo fetches information for a specific stop ID
-func requestInfo(stopID utils.StopId) ([]MonitoredStopVisit, error) {
-	params := url.Values{}
-	if stopID.Type == utils.Area {
-		params.Add("MonitoringRef", fmt.Sprintf("STIF:StopArea:SP:%s:", stopID.Id))
-	} else if stopID.Type == utils.Point {
-		params.Add("MonitoringRef", fmt.Sprintf("STIF:StopPoint:Q:%s:", stopID.Id))
-	} else {
-		return nil, fmt.Errorf("invalid stop ID type: %s", stopID.Type)
+// GetAllTimings retrieves all timings for the given stop IDs with typed data.
+// Stop IDs are requested in parallel; an error is only returned when none of them succeeded.
+func GetAllTimings(ctx context.Context, stopIDs []utils.StopId) (Timings, error) {
+	visits := make([][]siri.MonitoredStopVisit, len(stopIDs))
+	errs := make([]error, len(stopIDs))
+
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, max(1, env.IDFM_TIMINGS_WORKERS))
+
+	for index, stopID := range stopIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+
+			visits[index], errs[index] = getVisits(ctx, stopID)
+		}()
+	}
+	wg.Wait()
+
+	var timings Timings
+	for index, stopID := range stopIDs {
+		if errs[index] != nil {
+			timings.Failed = append(timings.Failed, FailedRef{
+				MonitoringRef: monitoringRefOrId(stopID),
+				Reason:        failureReason(errs[index]),
+			})
+			continue
+		}
+		timings.Visits = append(timings.Visits, visits[index]...)
+	}
+
+	if len(stopIDs) > 0 && len(timings.Failed) == len(stopIDs) {
+		return Timings{}, errors.Join(errs...)
+	}
+
+	return timings, nil
+}
+
+// monitoringRefPrefixes are the prefixes of the SIRI monitoring refs of each stop ID type
+var monitoringRefPrefixes = map[utils.StopType]string{
+	utils.Area:  "STIF:StopArea:SP:",
+	utils.Point: "STIF:StopPoint:Q:",
+}
+
+// monitoringRef builds the SIRI monitoring ref of a stop ID
+func monitoringRef(stopID utils.StopId) (string, error) {
+	prefix, ok := monitoringRefPrefixes[stopID.Type]
+	if !ok {
+		return "", fmt.Errorf("invalid stop ID type: %s", stopID.Type)
+	}
+	return prefix + stopID.Id + ":", nil
+}
+
+// ParseStopId parses a stop ID of that type, given either as a numeric ID such as "473921"
+// or as a monitoring ref such as "STIF:StopPoint:Q:473921:"
+func ParseStopId(stopType utils.StopType, value string) (utils.StopId, error) {
+	id := value
+	if prefix, ok := monitoringRefPrefixes[stopType]; ok && strings.HasPrefix(value, prefix) {
+		id = strings.TrimSuffix(strings.TrimPrefix(value, prefix), ":")
+	}
+
+	if id == "" || utils.OnlyNumberRegex.FindString(id) != id {
+		return utils.StopId{}, &utils.RequestError{Message: fmt.Sprintf("Invalid stop %s ID: %s", stopType, value)}
+	}
+	return utils.StopId{Id: id, Type: stopType}, nil
+}
+
+// monitoringRefOrId returns the monitoring ref of a stop ID, or its raw ID when it has no valid type
+func monitoringRefOrId(stopID utils.StopId) string {
+	if ref, err := monitoringRef(stopID); err == nil {
+		return ref
+	}
+	return stopID.Id
+}
+
+// failureReason classifies a request failure
+func failureReason(err error) string {
+	var exhaustedError *quota.ExhaustedError
+	if errors.As(err, &exhaustedError) {
+		return "quota"
 	}
 
-	client := &http.Client{
-		Timeout: 5 * time.Second,
-		Transport: &http.Transport{
-			TLSHandshakeTimeout: 5 * time.Second,
-		},
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return "timeout"
 	}
+	return "error"
+}
+
+// getVisits returns the visits of a stop ID from the cache, or from PRIM.
+// Concurrent requests for the same monitoring ref share a single upstream call.
+func getVisits(ctx context.Context, stopID utils.StopId) ([]siri.MonitoredStopVisit, error) {
+	ref, err := monitoringRef(stopID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checked first, so that whether a request without key succeeds does not depend on what is cached
+	clientKey, hasClientKey := apikey.ClientKey(ctx)
+	if !hasClientKey && !apikey.HasServerKeys() {
+		return nil, &utils.RequestError{Message: fmt.Sprintf("A PRIM API key must be provided in the %s header", apikey.ClientKeyHeader)}
+	}
+
+	cacheItem := data.StopMonitoringCache.Get(ref)
+	if cacheItem != nil && !cacheItem.IsExpired() {
+		return cacheItem.Value(), nil
+	}
+
+	// Save the remaining quota by serving stale visits when available
+	level := apikey.Level()
+	flightKey := ref
+	if hasClientKey {
+		level = quota.Check(clientKey)
+		// Calls made with different keys may fail differently, so they must not be shared
+		flightKey = ref + "|" + quota.Fingerprint(clientKey)
+	}
+	if level >= quota.Soft {
+		staleItem := data.StaleStopMonitoringCache.Get(ref)
+		if staleItem != nil && !staleItem.IsExpired() {
+			return staleItem.Value(), nil
+		}
+	}
+	if level == quota.Hard {
+		return nil, &quota.ExhaustedError{ResetAt: quota.ResetAt()}
+	}
+
+	resultChan := stopMonitoringGroup.DoChan(flightKey, func() (any, error) {
+		// The shared call must outlive the caller that happened to start it
+		visits, err := requestInfo(context.WithoutCancel(ctx), ref)
+		if err != nil {
+			return nil, err
+		}
+		if env.IDFM_TIMINGS_CACHE_TTL > 0 {
+			data.StopMonitoringCache.Set(ref, visits, ttlcache.DefaultTTL)
+		}
+		if env.IDFM_TIMINGS_STALE_TTL > 0 {
+			data.StaleStopMonitoringCache.Set(ref, visits, ttlcache.DefaultTTL)
+		}
+		return visits, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-resultChan:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.([]siri.MonitoredStopVisit), nil
+	}
+}
+
+// requestInfo fetches information for a specific monitoring ref with the client API key if any,
+// or with the server keys, failing over to the next key when PRIM rejects the current one
+func requestInfo(ctx context.Context, ref string) ([]siri.MonitoredStopVisit, error) {
+	if clientKey, ok := apikey.ClientKey(ctx); ok {
+		visits, err := requestInfoWithKey(ctx, ref, clientKey)
+
+		var statusError *upstream.StatusError
+		if errors.As(err, &statusError) && apikey.IsRejection(statusError.StatusCode) {
+			return nil, &apikey.RejectedError{StatusCode: statusError.StatusCode}
+		}
+		return visits, err
+	}
+
+	tried := map[string]bool{}
+	var lastErr error
+
+	for {
+		apiKey, err := apikey.Pick(tried)
+		if err != nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, err
+		}
+		tried[apiKey] = true
+
+		visits, err := requestInfoWithKey(ctx, ref, apiKey)
+
+		var statusError *upstream.StatusError
+		if errors.As(err, &statusError) && apikey.IsRejection(statusError.StatusCode) {
+			apikey.Bench(apiKey, statusError.StatusCode, statusError.RetryAfter)
+			lastErr = err
+			continue
+		}
+		return visits, err
+	}
+}
+
+// requestInfoWithKey fetches information for a specific monitoring ref with the given API key
+func requestInfoWithKey(ctx context.Context, ref string, apiKey string) ([]siri.MonitoredStopVisit, error) {
+	params := url.Values{}
+	params.Add("MonitoringRef", ref)
 
-	req, err := http.NewRequest("GET", stopMonitoringEndpoint+"?"+params.Encode(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", stopMonitoringEndpoint+"?"+params.Encode(), nil)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("accept", "application/json")
-	req.Header.Set("apiKey", env.IDFM_API_KEY)
+	req.Header.Set("apiKey", apiKey)
 
-	resp, err := client.Do(req)
+	resp, err := upstream.Prim.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, upstream.NewStatusError(resp)
 	}
 
 	var result StopMonitoringAPIResponse
@@ -0,0 +1,233 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"idfm/pkg/env"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	// baseBackoff is the delay before the first retry, doubled on each subsequent retry
+	baseBackoff = 200 * time.Millisecond
+	// maxRetryAfter is the longest Retry-After we are willing to wait for before giving up
+	maxRetryAfter = 5 * time.Second
+)
+
+// transport is shared by all upstream clients so that connections are reused across requests
+var transport = &http.Transport{
+	Proxy:               http.ProxyFromEnvironment,
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 20,
+	IdleConnTimeout:     90 * time.Second,
+	TLSHandshakeTimeout: 5 * time.Second,
+}
+
+var (
+	// OpenData is the client for the opendata portal (lines and stops referential)
+	OpenData = newClient("opendata", env.IDFM_OPENDATA_TIMEOUT)
+	// Prim is the client for the PRIM marketplace (real-time stop monitoring)
+	Prim = newClient("prim", env.IDFM_PRIM_TIMEOUT)
+)
+
+// StatusError is returned when an upstream answers with an unexpected status code
+type StatusError struct {
+	StatusCode int
+	// RetryAfter is the delay requested by the upstream through the Retry-After header, if any
+	RetryAfter time.Duration
+}
+
+// NewStatusError builds the error describing an unexpected response
+func NewStatusError(resp *http.Response) *StatusError {
+	retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"))
+	return &StatusError{StatusCode: resp.StatusCode, RetryAfter: retryAfter}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// Client sends requests to one upstream, retrying transient failures and failing fast during outages
+type Client struct {
+	name       string
+	httpClient *http.Client
+	breaker    *breaker
+	maxRetries int
+	onAttempt  func(req *http.Request, resp *http.Response)
+	// handedBack are the status codes returned to the caller at once rather than retried
+	handedBack []int
+}
+
+func newClient(name string, timeout time.Duration) *Client {
+	client := &Client{
+		name: name,
+		httpClient: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+		breaker:    newBreaker(env.IDFM_BREAKER_THRESHOLD, env.IDFM_BREAKER_COOLDOWN),
+		maxRetries: env.IDFM_UPSTREAM_RETRIES,
+	}
+	registerCircuitOpenMetric(client)
+	return client
+}
+
+// OnAttempt registers a hook called after each attempt, retries included, with its response if one was received
+func (c *Client) OnAttempt(hook func(req *http.Request, resp *http.Response)) {
+	c.onAttempt = hook
+}
+
+// HandBack makes the client return responses with these status codes at once instead of retrying them,
+// for callers that rather switch to another API key
+func (c *Client) HandBack(statusCodes ...int) {
+	c.handedBack = statusCodes
+}
+
+// Do sends the request, retrying transient failures with jittered exponential backoff.
+// Only requests without a body (such as GET requests) can be retried.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if !c.breaker.allow() {
+		recordAttempt(c.name, "rejected")
+		return nil, fmt.Errorf("%s upstream unavailable: %w", c.name, ErrCircuitOpen)
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.httpClient.Do(req.Clone(req.Context()))
+
+		if c.onAttempt != nil {
+			c.onAttempt(req, resp)
+		}
+
+		if err != nil && req.Context().Err() != nil {
+			// Cancelled by the caller, this tells nothing about the upstream
+			c.breaker.release()
+			recordAttempt(c.name, "cancelled")
+			return nil, err
+		}
+
+		if resp != nil && slices.Contains(c.handedBack, resp.StatusCode) {
+			// This is about the request rather than the upstream health
+			c.breaker.release()
+			recordAttempt(c.name, "handed_back")
+			return resp, nil
+		}
+
+		if !isTransient(resp, err) {
+			if resp.StatusCode >= http.StatusInternalServerError {
+				// Not worth retrying, but the upstream is failing all the same
+				c.breaker.failure()
+				recordAttempt(c.name, "failed")
+				return resp, nil
+			}
+			c.breaker.success()
+			recordAttempt(c.name, "ok")
+			return resp, nil
+		}
+
+		wait := backoff(attempt)
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				wait = retryAfter
+			}
+		}
+
+		if attempt >= c.maxRetries || wait > maxRetryAfter {
+			c.recordFailure(resp)
+			recordAttempt(c.name, "failed")
+			return resp, err
+		}
+
+		recordAttempt(c.name, "retried")
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if err := sleep(req.Context(), wait); err != nil {
+			c.breaker.release()
+			return nil, err
+		}
+	}
+}
+
+// GetJSON sends a GET request and decodes the JSON body of a successful response into v
+func (c *Client) GetJSON(url string, v any) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("accept", "application/json")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return NewStatusError(resp)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// recordFailure updates the breaker after the last attempt failed.
+// Rate limiting is about our usage rather than the upstream health, so it does not open the breaker.
+func (c *Client) recordFailure(resp *http.Response) {
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		c.breaker.release()
+	} else {
+		c.breaker.failure()
+	}
+}
+
+// isTransient tells whether a failed attempt is worth retrying
+func isTransient(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the jittered delay before the given retry
+func backoff(attempt int) time.Duration {
+	delay := baseBackoff << attempt
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// parseRetryAfter parses a Retry-After header holding either a number of seconds or an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(date)), true
+	}
+	return 0, false
+}
+
+// sleep waits for the given duration unless the context is done first
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+package upstream
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBreakerAccounting(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		opens      bool
+	}{
+		{"ok", http.StatusOK, false},
+		{"not found", http.StatusNotFound, false},
+		{"rate limited", http.StatusTooManyRequests, false},
+		{"handed back", http.StatusUnauthorized, false},
+		{"internal server error", http.StatusInternalServerError, true},
+		{"not implemented", http.StatusNotImplemented, true},
+		{"service unavailable", http.StatusServiceUnavailable, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.statusCode)
+			}))
+			defer server.Close()
+
+			client := &Client{
+				name:       "test",
+				httpClient: server.Client(),
+				breaker:    newBreaker(2, time.Minute),
+				handedBack: []int{http.StatusUnauthorized},
+			}
+
+			for range 2 {
+				req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("attempt failed: %s", err)
+				}
+				resp.Body.Close()
+			}
+
+			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			resp, err := client.Do(req)
+			if opened := errors.Is(err, ErrCircuitOpen); opened != test.opens {
+				t.Errorf("breaker open = %t after two %d responses, want %t", opened, test.statusCode, test.opens)
+			}
+			if resp != nil {
+				resp.Body.Close()
+			}
+		})
+	}
+}
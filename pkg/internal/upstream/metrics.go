@@ -0,0 +1,32 @@
+package upstream
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var attemptsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "idfm",
+	Name:      "upstream_attempts",
+	Help:      "Upstream request attempts by outcome",
+}, []string{"upstream", "outcome"})
+
+func recordAttempt(upstream string, outcome string) {
+	attemptsCounter.WithLabelValues(upstream, outcome).Inc()
+}
+
+func registerCircuitOpenMetric(client *Client) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "idfm",
+		Name:      "upstream_circuit_open",
+		Help:      "Whether the upstream circuit breaker is open",
+		ConstLabels: prometheus.Labels{
+			"upstream": client.name,
+		},
+	}, func() float64 {
+		if client.breaker.isOpen() {
+			return 1
+		}
+		return 0
+	})
+}
@@ -2,11 +2,25 @@ package utils
 
 import (
 	"regexp"
+	"time"
+	_ "time/tzdata" // the container image does not ship a time zone database
 )
 
 var OnlyNumberRegex = regexp.MustCompile(`[0-9]+`)
 
-var AllowedTransportTypes = []string{"metro", "bus", "rail", "tram"}
+// Paris is the time zone of Île-de-France Mobilités schedules and quotas
+var Paris = mustLoadLocation("Europe/Paris")
+
+var AllowedTransportTypes = []string{"metro", "bus", "rail", "tram", "funicular", "cableway"}
+
+// AllowedTransportSubmodes are the submodes of the referential, such as "suburbanRailway" for RER and Transilien
+// or "nightBus" for Noctilien
+var AllowedTransportSubmodes = []string{
+	"local", "suburbanRailway", "regionalRail", "railShuttle", "interregionalRail", "longDistance",
+	"localBus", "regionalBus", "expressBus", "nightBus", "airportLinkBus", "demandAndResponseBus", "schoolBus", "shuttleBus", "railReplacementBus",
+	"cityTram", "localTram",
+	"metro", "funicular", "telecabin", "cableCar",
+}
 
 // RequestError represents request-related errors that should return 400 Bad request
 type RequestError struct {
@@ -17,6 +31,16 @@ func (e *RequestError) Error() string {
 	return e.Message
 }
 
+// CandidatesError represents a request error that comes with the candidates the user can pick from
+type CandidatesError struct {
+	Message    string
+	Candidates any
+}
+
+func (e *CandidatesError) Error() string {
+	return e.Message
+}
+
 type StopType int
 
 const (
@@ -33,7 +57,19 @@ func (stopType StopType) String() string {
 	return stateName[stopType]
 }
 
+func (stopType StopType) MarshalText() ([]byte, error) {
+	return []byte(stopType.String()), nil
+}
+
 type StopId struct {
 	Id   string
 	Type StopType
 }
+
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return location
+}